@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aorliche/cards-ai/durak"
+	durakai "github.com/aorliche/cards-ai/durak/ai"
+	"github.com/aorliche/cards-ai/spades"
+)
+
+// Reads a durak JSONL record or a spades PBN record
+// and prints each move with its score, marking blunders with ??
+func main() {
+	game := flag.String("game", "durak", "durak or spades")
+	in := flag.String("in", "", "game record, stdin if empty")
+	budget := flag.Int64("budget", 10000, "milliseconds to search each move")
+	depth := flag.Int("depth", 20, "durak search depth")
+	threshold := flag.Float64("threshold", -1, "score drop that counts as a blunder (default 100 durak, 10 spades)")
+	flag.Parse()
+
+	f := os.Stdin
+	if *in != "" {
+		var err error
+		f, err = os.Open(*in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+	}
+	switch *game {
+		case "durak": {
+			rec, err := durak.ReadJSONL(f)
+			if err != nil {
+				log.Fatal(err)
+			}
+			state, err := rec.StateAt(0)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if *threshold < 0 {
+				*threshold = 100
+			}
+			annots, err := durakai.Analyze(state, rec.Actions, nil, *depth, *budget, *threshold)
+			for _,a := range annots {
+				fmt.Println(a.ToStr())
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		case "spades": {
+			rec, err := spades.ParsePBN(f)
+			if err != nil {
+				log.Fatal(err)
+			}
+			state, err := rec.StateAt(0)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if *threshold < 0 {
+				*threshold = 10
+			}
+			annots, err := spades.Analyze(state, rec.Actions, *budget, *threshold)
+			for _,a := range annots {
+				fmt.Println(a.ToStr())
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		default:
+			log.Fatalf("Unknown game %s", *game)
+	}
+}
@@ -1,6 +1,7 @@
 package ai
 
 import (
+	"encoding/json"
 	//"log"
 
 	"github.com/aorliche/cards-ai/search"
@@ -41,18 +42,21 @@ func (state *GameState) Debug(player int) []int {
 	return ints
 }
 
-func (state *GameState) Eval(player int) float64 {
-	params := state.Params
-	if params == nil {
-		params = &DefaultEvalParams
-	}
-	// Check my win
-	if state.Won[player] {
-		return params.WinBonus
-	}
-	// My hand
-	hval := 0.0
-	// Add pickup cards
+// Parts of Eval for one player
+type EvalBreakdown struct {
+	Won bool
+	// Ranks of cards in hand, unknown cards at a flat value
+	HandValue float64
+	TrumpBonus float64
+	HandSizePenalty float64
+	// Lowest opponent total, the one we're racing not to lose to
+	WorstOpponent float64
+	Total float64
+}
+
+// Hand value, trump bonus and size penalty for a player's hand
+// including cards they are picking up
+func (state *GameState) handParts(player int, params *EvalParams) (float64, float64, float64) {
 	h := append(make([]durak.Card, 0), state.Hands[player]...)
 	if state.PickingUp && player == state.Defender {
 		for i,c := range state.Plays {
@@ -62,68 +66,61 @@ func (state *GameState) Eval(player int) float64 {
 			}
 		}
 	}
+	val := 0.0
+	trump := 0.0
 	for _,c := range h {
 		if c == durak.UNK_CARD {
-			hval += params.UnknownCardValue
+			val += params.UnknownCardValue
 		} else {
-			hval += float64(c.Rank())
+			val += float64(c.Rank())
 		}
 		if c != durak.UNK_CARD && c.Suit() == state.Trump.Suit() {
-			hval += params.TrumpBonus
+			trump += params.TrumpBonus
 		}
 	}
+	penalty := params.BigDeckHandPenalty*float64(len(h))
 	if state.CardsInDeck <= params.CardsInDeckCutoff {
-		hval -= params.SmallDeckHandPenalty*float64(len(h))
-	} else {
-		hval -= params.BigDeckHandPenalty*float64(len(h))
+		penalty = params.SmallDeckHandPenalty*float64(len(h))
 	}
+	return val, trump, penalty
+}
+
+func (state *GameState) EvalBreakdown(player int) EvalBreakdown {
+	params := state.Params
+	if params == nil {
+		params = &DefaultEvalParams
+	}
+	// Check my win
+	if state.Won[player] {
+		return EvalBreakdown{Won: true, Total: params.WinBonus}
+	}
+	// My hand
+	var b EvalBreakdown
+	b.HandValue, b.TrumpBonus, b.HandSizePenalty = state.handParts(player, params)
 	// Other player's hands
-	ovals := make([]float64, 0)
-	for i,hh := range state.Hands {
+	// We only want to not be the worst
+	first := true
+	for i := range state.Hands {
 		if i == player {
 			continue
 		}
-		v := 0.0
 		// Check other player's win
-		if state.Won[i] {
-			ovals = append(ovals, params.WinBonus)
-			continue
-		}
-		// Add pickup cards
-		h := append(make([]durak.Card, 0), hh...)
-		if state.PickingUp && i == state.Defender {
-			for i,c := range state.Plays {
-				h = append(h, c)
-				if state.Covers[i] != durak.NO_CARD {
-					h = append(h, state.Covers[i])
-				}
-			}
-		}
-		for _,c := range h {
-			if c == durak.UNK_CARD {
-				v += params.UnknownCardValue
-			} else {
-				v += float64(c.Rank())
-			}
-			if c != durak.UNK_CARD && c.Suit() == state.Trump.Suit() {
-				v += params.TrumpBonus
-			}
+		v := params.WinBonus
+		if !state.Won[i] {
+			val, trump, penalty := state.handParts(i, params)
+			v = val + trump - penalty
 		}
-		if state.CardsInDeck <= params.CardsInDeckCutoff {
-			v -= params.SmallDeckHandPenalty*float64(len(h))
-		} else {
-			v -= params.BigDeckHandPenalty*float64(len(h))
+		if first || v < b.WorstOpponent {
+			b.WorstOpponent = v
+			first = false
 		}
-		ovals = append(ovals, v)
 	}
-	// We only want to not be the worst
-	worst := ovals[0]
-	for _,v := range ovals {
-		if v < worst {
-			worst = v
-		}
-	}
-	return hval - worst
+	b.Total = b.HandValue + b.TrumpBonus - b.HandSizePenalty - b.WorstOpponent
+	return b
+}
+
+func (state *GameState) Eval(player int) float64 {
+	return state.EvalBreakdown(player).Total
 }
 
 func (state *GameState) Children(player int) ([]search.Action, []search.GameState) {
@@ -152,11 +149,58 @@ func (state *GameState) Children(player int) ([]search.Action, []search.GameStat
 }
 
 func (state *GameState) FindBestAction(player int, depth int, timeBudget int64) (durak.Action, bool) {
+	act, _, ok := state.SuggestAction(player, depth, timeBudget)
+	return act, ok
+}
+
+// Also returns the evaluation of the line the search expects
+func (state *GameState) SuggestAction(player int, depth int, timeBudget int64) (durak.Action, float64, bool) {
 	st := state.Clone()
 	st.Mask(player)
 	state = &GameState{*st, state.Params}
-	iface, _ := search.SearchItDeep(state, player, depth, timeBudget)
+	iface, e := search.SearchItDeep(state, player, depth, timeBudget)
 	act, ok := iface.(durak.Action)
-	return act, ok
+	return act, e, ok
 }
 
+
+type ScoredAction struct {
+	Action durak.Action
+	Description string
+	Score float64
+}
+
+// Why a bot picked its action
+type Explanation struct {
+	Player int
+	// Best first, at most top k
+	Candidates []ScoredAction
+	// Eval of the position the bot expects after its move
+	Expected EvalBreakdown
+}
+
+func (e *Explanation) ToStr() string {
+	jsn, _ := json.Marshal(e)
+	return string(jsn)
+}
+
+// FindBestAction with the top k candidates and the expected line's eval
+func (state *GameState) ExplainBestAction(player int, depth int, timeBudget int64, k int) (durak.Action, *Explanation, bool) {
+	st := state.Clone()
+	st.Mask(player)
+	state = &GameState{*st, state.Params}
+	cands := search.SearchCandidates(state, player, depth, timeBudget)
+	if len(cands) == 0 {
+		return durak.Action{}, nil, false
+	}
+	exp := &Explanation{
+		Player: player,
+		Candidates: make([]ScoredAction, 0, k),
+		Expected: cands[0].State.(*GameState).EvalBreakdown(player),
+	}
+	for i := 0; i < len(cands) && i < k; i++ {
+		act := cands[i].Action.(durak.Action)
+		exp.Candidates = append(exp.Candidates, ScoredAction{act, act.Describe(), cands[i].Score})
+	}
+	return cands[0].Action.(durak.Action), exp, true
+}
@@ -69,3 +69,125 @@ func TestBadDefer(t *testing.T) {
 		t.Error("Didn't do correct defer, ", act.ToStr())
 	}
 }
+
+func TestBotsPlayGame(t *testing.T) {
+	bots := []Bot{RandomBot, GreedyBot, RandomBot}
+	for i := 0; i < 10; i++ {
+		state := &GameState{GameState: *durak.InitGameState(3)}
+		count := 0
+		for !state.IsOver() {
+			acted := false
+			for p,bot := range bots {
+				act, _, ok := bot(state, p)
+				if !ok {
+					continue
+				}
+				if durak.IndexOf(state.PlayerActions(p), act) == -1 {
+					t.Fatalf("Illegal action %v", act.ToStr())
+				}
+				state.TakeAction(act)
+				acted = true
+			}
+			if !acted {
+				t.Fatalf("No bot could act")
+			}
+			count++
+			if count > 1000 {
+				t.Fatalf("Game too long")
+			}
+		}
+	}
+}
+
+func TestGreedyPlaysLowest(t *testing.T) {
+	state := &GameState{GameState: *durak.InitGameState(2)}
+	state.Attacker = 0
+	state.Defender = 1
+	state.Trump = durak.CardFromRankSuit(0, 3)
+	state.Hands[0] = []durak.Card{durak.CardFromRankSuit(8, 0), durak.CardFromRankSuit(0, 3), durak.CardFromRankSuit(2, 1)}
+	act, _, ok := GreedyBot(state, 0)
+	if !ok || act.Card != durak.CardFromRankSuit(2, 1) {
+		t.Errorf("Greedy didn't play its lowest card %v", act.ToStr())
+	}
+}
+
+func TestBotByName(t *testing.T) {
+	for _,name := range []string{"Random", "Greedy", "Easy", "Medium", "Hard"} {
+		if _, err := BotByName(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := BotByName("Grandmaster"); err == nil {
+		t.Error("No error for unknown bot")
+	}
+	for _,name := range []string{"Greedy", "Easy"} {
+		if _, err := BotWithBudget(name, 50); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := BotWithBudget("Grandmaster", 50); err == nil {
+		t.Error("No error for unknown bot with a budget")
+	}
+}
+
+func TestExplainBestAction(t *testing.T) {
+	state := &GameState{*durak.InitGameState(2), nil}
+	state.Attacker = 0
+	state.Defender = 1
+	state.Hands[0] = []durak.Card{durak.Card(10), durak.Card(21)}[:]
+	state.Hands[1] = []durak.Card{durak.Card(20), durak.Card(23)}[:]
+	state.Plays = []durak.Card{durak.Card(1)}[:]
+	state.Covers = []durak.Card{durak.Card(-2)}[:]
+	state.Trump = durak.Card(11)
+	act, exp, ok := state.ExplainBestAction(0, 10, 100, 2)
+	if !ok || act.Verb != durak.DeferVerb {
+		t.Fatal("Didn't do correct defer, ", act.ToStr())
+	}
+	if len(exp.Candidates) != 2 || exp.Candidates[0].Action != act {
+		t.Errorf("Bad candidates %v", exp.ToStr())
+	}
+	if exp.Candidates[0].Score < exp.Candidates[1].Score {
+		t.Errorf("Candidates not sorted %v", exp.ToStr())
+	}
+	if exp.Expected.Total != exp.Candidates[0].Score {
+		t.Errorf("Expected line eval %v doesn't match score %v", exp.Expected.Total, exp.Candidates[0].Score)
+	}
+}
+
+func TestEvalBreakdown(t *testing.T) {
+	state := &GameState{*durak.InitGameState(3), nil}
+	for p := 0; p < 3; p++ {
+		b := state.EvalBreakdown(p)
+		if b.HandValue + b.TrumpBonus - b.HandSizePenalty - b.WorstOpponent != state.Eval(p) {
+			t.Errorf("Breakdown %v doesn't add up to %v", b, state.Eval(p))
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	state := durak.InitGameState(2)
+	state.Attacker = 0
+	state.Defender = 1
+	state.Hands[0] = []durak.Card{durak.Card(10), durak.Card(21)}[:]
+	state.Hands[1] = []durak.Card{durak.Card(20), durak.Card(23)}[:]
+	state.Plays = []durak.Card{durak.Card(1)}[:]
+	state.Covers = []durak.Card{durak.Card(-2)}[:]
+	state.Trump = durak.Card(11)
+	var played durak.Action
+	for _,a := range state.PlayerActions(0) {
+		if a.Verb != durak.DeferVerb {
+			played = a
+		}
+	}
+	annots, err := Analyze(state, []durak.Action{played}, nil, 10, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := annots[0]
+	if a.Best.Verb != durak.DeferVerb || !a.Blunder {
+		t.Errorf("Didn't flag blunder %v", a.ToStr())
+	}
+	if _, err := Analyze(state, []durak.Action{{Player: 1, Verb: durak.PassVerb}}, nil, 10, 100, 0); err == nil {
+		t.Error("No error for illegal action")
+	}
+}
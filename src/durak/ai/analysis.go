@@ -0,0 +1,72 @@
+package ai
+
+import (
+	"fmt"
+
+	"github.com/aorliche/cards-ai/durak"
+	"github.com/aorliche/cards-ai/search"
+)
+
+// One decision in a finished game, re-searched
+type Annotation struct {
+	Move int
+	Action durak.Action
+	Description string
+	// Search score of the move played
+	Score float64
+	// Best alternative found
+	Best durak.Action
+	BestDescription string
+	BestScore float64
+	// Played move scores more than the threshold below the best
+	Blunder bool
+	// Played move wasn't among the searched candidates
+	Unscored bool
+}
+
+func (a Annotation) ToStr() string {
+	mark := ""
+	if a.Blunder {
+		mark = " ??"
+	}
+	if a.Unscored {
+		return fmt.Sprintf("%d. P%d %s (not scored)", a.Move, a.Action.Player, a.Description)
+	}
+	if a.Action == a.Best {
+		return fmt.Sprintf("%d. P%d %s %.0f", a.Move, a.Action.Player, a.Description, a.Score)
+	}
+	return fmt.Sprintf("%d. P%d %s %.0f%s (best %s %.0f)", a.Move, a.Action.Player, a.Description, a.Score, mark, a.BestDescription, a.BestScore)
+}
+
+// Replay a game and search each decision from the mover's point of view
+// Flags moves scoring more than threshold below the best alternative
+func Analyze(initial *durak.GameState, actions []durak.Action, params *EvalParams, depth int, timeBudget int64, threshold float64) ([]Annotation, error) {
+	state := &GameState{*initial.Clone(), params}
+	annots := make([]Annotation, 0, len(actions))
+	for i,act := range actions {
+		if durak.IndexOf(state.PlayerActions(act.Player), act) == -1 {
+			return annots, fmt.Errorf("Illegal action %d: %s", i, act.ToStr())
+		}
+		st := state.Clone()
+		st.Mask(act.Player)
+		cands := search.SearchCandidates(&GameState{*st, params}, act.Player, depth, timeBudget)
+		annot := Annotation{Move: i, Action: act, Description: act.Describe(), Unscored: true}
+		if len(cands) > 0 {
+			best := cands[0].Action.(durak.Action)
+			annot.Best = best
+			annot.BestDescription = best.Describe()
+			annot.BestScore = cands[0].Score
+		}
+		for _,c := range cands {
+			if c.Action.(durak.Action) == act {
+				annot.Score = c.Score
+				annot.Unscored = false
+				annot.Blunder = annot.BestScore - annot.Score > threshold
+				break
+			}
+		}
+		annots = append(annots, annot)
+		state.TakeAction(act)
+	}
+	return annots, nil
+}
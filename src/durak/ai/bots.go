@@ -0,0 +1,103 @@
+package ai
+
+import (
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/aorliche/cards-ai/durak"
+)
+
+// Picks an action for player, false if there's nothing to do
+// Bots that search also say why
+type Bot func(state *GameState, player int) (durak.Action, *Explanation, bool)
+
+// Any legal action
+func RandomBot(state *GameState, player int) (durak.Action, *Explanation, bool) {
+	acts := state.PlayerActions(player)
+	if len(acts) == 0 {
+		return durak.Action{}, nil, false
+	}
+	return acts[rand.IntN(len(acts))], nil, true
+}
+
+// Trumps are worth more than any plain card
+func (state *GameState) CardValue(c durak.Card) int {
+	if c.Suit() == state.Trump.Suit() {
+		return c.Rank() + 9
+	}
+	return c.Rank()
+}
+
+// Always gets rid of its lowest card
+// Only adds plain cards to an attack it could pass on
+func GreedyBot(state *GameState, player int) (durak.Action, *Explanation, bool) {
+	acts := state.PlayerActions(player)
+	canPass := false
+	for _,a := range acts {
+		if a.Verb == durak.PassVerb {
+			canPass = true
+		}
+	}
+	best := -1
+	for i,a := range acts {
+		if a.Verb != durak.PlayVerb && a.Verb != durak.CoverVerb && a.Verb != durak.ReverseVerb {
+			continue
+		}
+		if canPass && a.Card.Suit() == state.Trump.Suit() {
+			continue
+		}
+		if best == -1 || state.CardValue(a.Card) < state.CardValue(acts[best].Card) {
+			best = i
+		}
+	}
+	if best != -1 {
+		return acts[best], nil, true
+	}
+	for _,verb := range []durak.Verb{durak.PassVerb, durak.PickUpVerb, durak.DeferVerb} {
+		for _,a := range acts {
+			if a.Verb == verb {
+				return a, nil, true
+			}
+		}
+	}
+	return durak.Action{}, nil, false
+}
+
+// Iterative deepening search from player's masked point of view
+func MinimaxBot(depth int, timeBudget int64) Bot {
+	return func(state *GameState, player int) (durak.Action, *Explanation, bool) {
+		return state.ExplainBestAction(player, depth, timeBudget, 3)
+	}
+}
+
+var Bots = map[string]Bot{
+	"Random": RandomBot,
+	"Greedy": GreedyBot,
+	"Easy": MinimaxBot(4, 200),
+	"Medium": MinimaxBot(12, 2000),
+	"Hard": MinimaxBot(16, 5000),
+}
+
+// Search depth of the minimax bots
+var Depths = map[string]int{
+	"Easy": 4,
+	"Medium": 12,
+	"Hard": 16,
+}
+
+func BotByName(name string) (Bot, error) {
+	bot, ok := Bots[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown player type %s", name)
+	}
+	return bot, nil
+}
+
+// Searching bots think for timeBudget ms instead of their default
+// The others don't search and ignore it
+func BotWithBudget(name string, timeBudget int64) (Bot, error) {
+	if depth, ok := Depths[name]; ok && timeBudget > 0 {
+		return MinimaxBot(depth, timeBudget), nil
+	}
+	return BotByName(name)
+}
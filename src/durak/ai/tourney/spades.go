@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/aorliche/cards-ai/spades"
+)
+
+// Spades in the same harness: the generic search against a bot level
+// Every deal is played twice with the sides swapped to cancel out the cards
+var spadesDeals = 10
+var spadesDepth = 8
+var spadesBudget int64 = 1000
+var spadesLevel = "Medium"
+
+func saveSpadesRecord(rec *spades.Record, side int) {
+	if recordDir == "" {
+		return
+	}
+	f, err := os.Create(filepath.Join(recordDir, fmt.Sprintf("tourney-spades-%d-%d.pbn", rec.Seed, side)))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	if err := rec.WritePBN(f); err != nil {
+		log.Println(err)
+	}
+}
+
+// One hand with side searching and the other side at level
+// Everyone decides from what they can see
+func playSpades(seed int64, side int, level *spades.Level) *spades.GameState {
+	state := spades.InitGameStateSeed(seed)
+	names := [4]string{}
+	for i := range names {
+		names[i] = level.Name
+		if i%2 == side {
+			names[i] = "Search"
+		}
+	}
+	rec := spades.NewRecord(state, names)
+	rec.Seed = seed
+	for !state.IsOver() {
+		for p := 0; p < 4; p++ {
+			st := state.Clone()
+			st.Mask(p)
+			var act spades.Action
+			var ok bool
+			if p%2 == side {
+				act, ok = st.FindBestAction(p, spadesDepth, spadesBudget)
+			} else {
+				act, ok = st.DecideAction(p, level)
+			}
+			if !ok {
+				continue
+			}
+			if !spades.Includes(state.PlayerActions(p), act) {
+				log.Println("Illegal action", act.ToStr())
+				acts := state.PlayerActions(p)
+				act = acts[0]
+			}
+			rec.TakeAction(state, act)
+		}
+	}
+	saveSpadesRecord(rec, side)
+	return state
+}
+
+func spadesTourney() error {
+	level, ok := spades.LevelByName(spadesLevel)
+	if !ok {
+		return fmt.Errorf("Unknown spades level %q", spadesLevel)
+	}
+	// Search first, then the level
+	points := [2]int{}
+	for i := 0; i < spadesDeals; i++ {
+		seed := firstSeed + int64(i)
+		for side := 0; side < 2; side++ {
+			state := playSpades(seed, side, level)
+			scores := state.ScoreHand([2]spades.Score{}, true)
+			points[0] += scores[side].Points
+			points[1] += scores[1-side].Points
+			log.Println(seed, side, state.Bids, state.Tricks)
+			log.Printf("Search %d, %s %d", points[0], level.Name, points[1])
+		}
+	}
+	return nil
+}
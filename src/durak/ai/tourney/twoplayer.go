@@ -1,7 +1,11 @@
 package main 
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -19,11 +23,52 @@ var bigDeck = []float64{5.0}
 var nSimulGames = 10
 var nBatch = 3
 
+// Finished games of both kinds are saved here, none if empty
+var recordDir = "records"
+var nRecords = 0
+
+// Game i of batch b is dealt from the same seed for every set of params
+var firstSeed int64 = 1
+
+func saveRecord(rec *durak.Record) {
+	if recordDir == "" {
+		return
+	}
+	nRecords++
+	f, err := os.Create(filepath.Join(recordDir, fmt.Sprintf("tourney-%d.jsonl", nRecords)))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	if err := rec.WriteJSONL(f); err != nil {
+		log.Println(err)
+	}
+}
+
 func main() {
-	startGame := func (params *ai.EvalParams) *ai.GameState {
+	game := flag.String("game", "durak", "durak or spades")
+	flag.StringVar(&spadesLevel, "level", spadesLevel, "spades bot level the search plays against")
+	flag.StringVar(&recordDir, "records", recordDir, "directory to save finished game records, none if empty")
+	flag.Parse()
+	if recordDir != "" {
+		if err := os.MkdirAll(recordDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *game == "spades" {
+		if err := spadesTourney(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	var recMutex sync.Mutex
+	startGame := func (params *ai.EvalParams, seed int64) *ai.GameState {
 		// Init game state
 		var mutex sync.Mutex
-		state := &ai.GameState{*durak.InitGameState(2), nil}
+		state := &ai.GameState{GameState: *durak.InitGameStateSeed(2, seed)}
+		rec := durak.NewRecord(&state.GameState, []string{"Default", "Params"})
+		rec.Seed = seed
 		stime := time.Now()
 		// AI Logic
 		aiFunc := func (player int) {
@@ -40,7 +85,7 @@ func main() {
 				if player == 0 {
 					ps = nil
 				}
-				st := &ai.GameState{*state.Clone(), ps}
+				st := &ai.GameState{GameState: *state.Clone(), Params: ps}
 				mutex.Unlock()
 				act, ok := st.FindBestAction(player, 12, 2000)
 				if !ok {
@@ -50,8 +95,13 @@ func main() {
 				acts := state.PlayerActions(player)
 				for _,a := range acts {
 					if a == act {
-						log.Println(state.CardsInDeck, act.ToStr())
-						state.TakeAction(act)
+						log.Println(state.CardsInDeck, act.Notation())
+						rec.TakeAction(&state.GameState, act)
+						if state.IsOver() {
+							recMutex.Lock()
+							saveRecord(rec)
+							recMutex.Unlock()
+						}
 						break
 					}
 				}
@@ -67,18 +117,18 @@ func main() {
 		a0 := a % 3
 		a1 := (a/3) % 3
 		params := &ai.EvalParams{
-			winBonus[0],
-			trumpBonus[0],
-			unknown[a0],
-			cardsCutoff[a1],
-			smallDeck[0],
-			bigDeck[0],
+			WinBonus: winBonus[0],
+			TrumpBonus: trumpBonus[0],
+			UnknownCardValue: unknown[a0],
+			CardsInDeckCutoff: cardsCutoff[a1],
+			SmallDeckHandPenalty: smallDeck[0],
+			BigDeckHandPenalty: bigDeck[0],
 		}
 		w := 0
 		for b := 0; b < nBatch; b++ {
 			states := make([]*ai.GameState, nSimulGames)
 			for i := 0; i < nSimulGames; i++ {
-				states[i] = startGame(params)
+				states[i] = startGame(params, firstSeed + int64(b*nSimulGames + i))
 			}
 			numActive := func () int {
 				n := 0
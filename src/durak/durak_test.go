@@ -1,7 +1,10 @@
 package durak
 
 import (
+	"bytes"
 	"math/rand/v2"
+	"reflect"
+	"strings"
 	"testing"
 	//"fmt"
 )
@@ -78,3 +81,184 @@ func TestRandom6PlayerGame(t *testing.T) {
 		}
 	}
 }
+
+func recordRandomGame(nPlayers int) (*Record, *GameState) {
+	state := InitGameState(nPlayers)
+	rec := NewRecord(state, []string{"Alice", "Bob \"B\"", "Random"}[:nPlayers])
+	for !state.IsOver() {
+		acts := state.AllActions()
+		rec.TakeAction(state, acts[rand.IntN(len(acts))])
+	}
+	return rec, state
+}
+
+func TestRecordJSONL(t *testing.T) {
+	rec, final := recordRandomGame(3)
+	var buf bytes.Buffer
+	if err := rec.WriteJSONL(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ReadJSONL(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rec, loaded) {
+		t.Errorf("Loaded record differs")
+	}
+	state, err := loaded.StateAt(len(loaded.Actions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state.Won, final.Won) || !reflect.DeepEqual(state.Hands, final.Hands) {
+		t.Errorf("Replay doesn't reach the same end")
+	}
+}
+
+func TestRecordNotation(t *testing.T) {
+	rec, _ := recordRandomGame(3)
+	loaded, err := ParseNotation(strings.NewReader(rec.Notation()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rec, loaded) {
+		t.Errorf("Parsed notation differs\n%s", rec.Notation())
+	}
+}
+
+func TestRecordStateAt(t *testing.T) {
+	rec, _ := recordRandomGame(2)
+	state := DealGameState(2, rec.Deck)
+	for i,act := range rec.Actions[:10] {
+		st, err := rec.StateAt(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(st, state) {
+			t.Errorf("Wrong state at move %d", i)
+		}
+		state.TakeAction(act)
+	}
+	if _, err := rec.StateAt(len(rec.Actions)+1); err == nil {
+		t.Errorf("No error past the end")
+	}
+	rec.Actions[0].Player = 1 - rec.Actions[0].Player
+	if _, err := rec.StateAt(1); err == nil {
+		t.Errorf("No error for illegal action")
+	}
+}
+
+func TestInitGameStateSeed(t *testing.T) {
+	a := InitGameStateSeed(3, 12345)
+	b := InitGameStateSeed(3, 12345)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Same seed dealt differently")
+	}
+	c := InitGameStateSeed(3, 54321)
+	if reflect.DeepEqual(a.Deck, c.Deck) {
+		t.Errorf("Different seeds dealt the same deck")
+	}
+}
+
+func TestNoReverseSmallDeck(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		deck := GenerateDeckSizeRand(24, nil)
+		if len(deck) != 24 {
+			t.Fatalf("Deck has %d cards", len(deck))
+		}
+		for _,c := range deck {
+			if c.Rank() < 3 {
+				t.Fatalf("%s in a 24 card deck", c.ToStr())
+			}
+		}
+		state := DealGameState(4, deck)
+		state.NoReverse = true
+		rec := NewRecord(state, []string{"A", "B", "C", "D"})
+		for !state.IsOver() {
+			acts := state.AllActions()
+			for _,act := range acts {
+				if act.Verb == ReverseVerb {
+					t.Fatalf("Reverse allowed without the rule")
+				}
+			}
+			rec.TakeAction(state, acts[rand.IntN(len(acts))])
+		}
+		loaded, err := ParseNotation(strings.NewReader(rec.Notation()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		final, err := loaded.StateAt(len(loaded.Actions))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !final.NoReverse || !reflect.DeepEqual(final.Won, state.Won) {
+			t.Errorf("Replay lost the rules")
+		}
+	}
+}
+
+func TestDealBadDeck(t *testing.T) {
+	rec, _ := recordRandomGame(2)
+	for _,deck := range [][]Card{
+		append(GenerateDeck(), Card(0)),
+		append(GenerateDeck()[:35], Card(36)),
+	} {
+		rec.Deck = deck
+		if _, err := rec.StateAt(0); err == nil {
+			t.Errorf("Dealt from a bad deck %v", deck)
+		}
+	}
+	// Duplicates are caught too
+	deck := GenerateDeck()
+	deck[1] = deck[0]
+	if DealGameState(2, deck) != nil {
+		t.Errorf("Dealt a card twice")
+	}
+}
+
+// With 24 cards and 4 players nothing is left to draw, but the deal order is still secret
+func TestMaskSmallDeck(t *testing.T) {
+	state := DealGameState(4, GenerateDeckSizeRand(24, nil))
+	if state.CardsInDeck != 0 {
+		t.Fatalf("%d cards left to draw", state.CardsInDeck)
+	}
+	for _,me := range []int{-1, 0} {
+		st := state.Clone()
+		st.Mask(me)
+		for i,c := range st.Deck[:len(st.Deck)-1] {
+			if c != UNK_CARD {
+				t.Errorf("Seat %d sees deck card %d, %s", me, i, c.ToStr())
+			}
+		}
+		if st.Deck[len(st.Deck)-1] != state.Trump {
+			t.Errorf("Trump hidden from seat %d", me)
+		}
+	}
+}
+
+// The next attacker ran out as the deck did, so the turn moves on
+func TestAttackerOutAfterDeal(t *testing.T) {
+	state := InitGameState(3)
+	suit := (state.Trump.Suit()+1)%4
+	card := func (rank int) Card { return CardFromRankSuit(rank, suit) }
+	state.Hands = [][]Card{{card(0), card(1)}, {card(5)}, {card(2), card(3), card(4), card(6), card(7), card(8)}}
+	state.Known = [][]Card{{}, {}, {}}
+	state.CardsInDeck = 1
+	state.Attacker, state.Defender, state.Dir = 0, 1, 1
+	for _,act := range []Action{
+		{0, PlayVerb, card(0), NO_CARD},
+		{1, CoverVerb, card(5), card(0)},
+		{0, PassVerb, NO_CARD, NO_CARD},
+		{2, PassVerb, NO_CARD, NO_CARD},
+	} {
+		if IndexOf(state.PlayerActions(act.Player), act) == -1 {
+			t.Fatalf("Can't %s", act.ToStr())
+		}
+		state.TakeAction(act)
+	}
+	if !state.Won[1] || state.Attacker != 2 || state.Defender != 0 {
+		t.Errorf("Bad roles, attacker %d defender %d won %v", state.Attacker, state.Defender, state.Won)
+	}
+	if len(state.AllActions()) == 0 {
+		t.Errorf("Nobody can move")
+	}
+}
@@ -0,0 +1,288 @@
+package durak
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Transfer durak, the defender may reverse with a card of the same rank
+var DefaultRules = "Reverse"
+
+// Plain durak without reversing
+var NoReverseRules = "NoReverse"
+
+// Everything needed to deal the game again
+type RecordHeader struct {
+	// Names of humans, types of bots
+	Players []string
+	Rules string
+	// Zero when the deck wasn't dealt from a seed
+	Seed int64
+	Trump Card
+	// Whole deck in deal order, the first six cards go to player 0 and so on
+	Deck []Card
+}
+
+type Record struct {
+	RecordHeader
+	Actions []Action
+}
+
+// Start recording a freshly dealt game
+func NewRecord(state *GameState, players []string) *Record {
+	return &Record{
+		RecordHeader: RecordHeader{
+			Players: append(make([]string, 0), players...),
+			Rules: state.Rules(),
+			Trump: state.Trump,
+			Deck: append(make([]Card, 0), state.Deck...),
+		},
+		Actions: make([]Action, 0),
+	}
+}
+
+// Rules name for records
+func (state *GameState) Rules() string {
+	if state.NoReverse {
+		return NoReverseRules
+	}
+	return DefaultRules
+}
+
+// Take an action and record it
+func (rec *Record) TakeAction(state *GameState, act Action) {
+	state.TakeAction(act)
+	rec.Actions = append(rec.Actions, act)
+}
+
+// Position after the first n actions
+func (rec *Record) StateAt(n int) (*GameState, error) {
+	if n < 0 || n > len(rec.Actions) {
+		return nil, fmt.Errorf("Move %d out of range 0-%d", n, len(rec.Actions))
+	}
+	state := DealGameState(len(rec.Players), rec.Deck)
+	if state == nil {
+		return nil, errors.New("Bad players or deck in record")
+	}
+	switch rec.Rules {
+		case DefaultRules:
+		case NoReverseRules: state.NoReverse = true
+		default: return nil, fmt.Errorf("Unknown rules %s", rec.Rules)
+	}
+	if state.Trump != rec.Trump {
+		return nil, errors.New("Trump doesn't match deck")
+	}
+	for i,act := range rec.Actions[:n] {
+		if IndexOf(state.PlayerActions(act.Player), act) == -1 {
+			return nil, fmt.Errorf("Illegal action %d: %s", i, act.Notation())
+		}
+		state.TakeAction(act)
+	}
+	return state, nil
+}
+
+// Header on the first line, then one action per line
+func (rec *Record) WriteJSONL(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(rec.RecordHeader); err != nil {
+		return err
+	}
+	for _,act := range rec.Actions {
+		if err := enc.Encode(act); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ReadJSONL(r io.Reader) (*Record, error) {
+	dec := json.NewDecoder(r)
+	rec := &Record{Actions: make([]Action, 0)}
+	if err := dec.Decode(&rec.RecordHeader); err != nil {
+		return nil, err
+	}
+	for {
+		var act Action
+		err := dec.Decode(&act)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec.Actions = append(rec.Actions, act)
+	}
+	return rec, nil
+}
+
+var noteRanks = []string{"6", "7", "8", "9", "10", "J", "Q", "K", "A"}
+var noteSuits = []string{"c", "s", "h", "d"}
+var noteVerbs = []string{"play", "cover", "reverse", "pass", "pickup", "defer"}
+
+// Short card name like 10h or Qs
+func (card Card) Notation() string {
+	if card == UNK_CARD {
+		return "?"
+	}
+	if card == NO_CARD {
+		return "-"
+	}
+	return noteRanks[card.Rank()] + noteSuits[card.Suit()]
+}
+
+func ParseCard(s string) (Card, error) {
+	switch s {
+		case "?": return UNK_CARD, nil
+		case "-": return NO_CARD, nil
+	}
+	if len(s) < 2 {
+		return NO_CARD, fmt.Errorf("Bad card %q", s)
+	}
+	rank := IndexOf(noteRanks, s[:len(s)-1])
+	suit := IndexOf(noteSuits, s[len(s)-1:])
+	if rank == -1 || suit == -1 {
+		return NO_CARD, fmt.Errorf("Bad card %q", s)
+	}
+	return CardFromRankSuit(rank, suit), nil
+}
+
+// Player, verb and cards, e.g. "1 cover 9h Jh" covers the 9 with the jack
+func (a Action) Notation() string {
+	switch a.Verb {
+		case PlayVerb, ReverseVerb:
+			return fmt.Sprintf("%d %s %s", a.Player, noteVerbs[a.Verb], a.Card.Notation())
+		case CoverVerb:
+			return fmt.Sprintf("%d %s %s %s", a.Player, noteVerbs[a.Verb], a.Covering.Notation(), a.Card.Notation())
+	}
+	return fmt.Sprintf("%d %s", a.Player, noteVerbs[a.Verb])
+}
+
+func ParseAction(s string) (Action, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return Action{}, fmt.Errorf("Bad action %q", s)
+	}
+	player, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return Action{}, fmt.Errorf("Bad action %q", s)
+	}
+	verb := IndexOf(noteVerbs, fields[1])
+	if verb == -1 {
+		return Action{}, fmt.Errorf("Bad action %q", s)
+	}
+	act := Action{player, Verb(verb), NO_CARD, NO_CARD}
+	want := 2
+	switch act.Verb {
+		case PlayVerb, ReverseVerb: want = 3
+		case CoverVerb: want = 4
+	}
+	if len(fields) != want {
+		return Action{}, fmt.Errorf("Bad action %q", s)
+	}
+	if want >= 3 {
+		if act.Card, err = ParseCard(fields[want-1]); err != nil {
+			return Action{}, err
+		}
+	}
+	if want == 4 {
+		if act.Covering, err = ParseCard(fields[2]); err != nil {
+			return Action{}, err
+		}
+	}
+	return act, nil
+}
+
+func cardsNotation(cards []Card) string {
+	strs := make([]string, len(cards))
+	for i,c := range cards {
+		strs[i] = c.Notation()
+	}
+	return strings.Join(strs, " ")
+}
+
+// Header lines, a blank line, then one action per line
+func (rec *Record) Notation() string {
+	var b strings.Builder
+	names := make([]string, len(rec.Players))
+	for i,p := range rec.Players {
+		names[i] = strconv.Quote(p)
+	}
+	fmt.Fprintf(&b, "Players: %s\n", strings.Join(names, " "))
+	fmt.Fprintf(&b, "Rules: %s\n", rec.Rules)
+	fmt.Fprintf(&b, "Seed: %d\n", rec.Seed)
+	fmt.Fprintf(&b, "Trump: %s\n", rec.Trump.Notation())
+	fmt.Fprintf(&b, "Deck: %s\n", cardsNotation(rec.Deck))
+	b.WriteString("\n")
+	for _,act := range rec.Actions {
+		b.WriteString(act.Notation())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func ParseNotation(r io.Reader) (*Record, error) {
+	rec := &Record{Actions: make([]Action, 0)}
+	scanner := bufio.NewScanner(r)
+	header := true
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			header = false
+			continue
+		}
+		if !header {
+			act, err := ParseAction(line)
+			if err != nil {
+				return nil, err
+			}
+			rec.Actions = append(rec.Actions, act)
+			continue
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("Bad header line %q", line)
+		}
+		val = strings.TrimSpace(val)
+		var err error
+		switch key {
+			case "Players":
+				for val != "" {
+					var q string
+					if q, err = strconv.QuotedPrefix(val); err != nil {
+						break
+					}
+					name, _ := strconv.Unquote(q)
+					rec.Players = append(rec.Players, name)
+					val = strings.TrimSpace(val[len(q):])
+				}
+			case "Rules":
+				rec.Rules = val
+			case "Seed":
+				rec.Seed, err = strconv.ParseInt(val, 10, 64)
+			case "Trump":
+				rec.Trump, err = ParseCard(val)
+			case "Deck":
+				for _,f := range strings.Fields(val) {
+					var c Card
+					if c, err = ParseCard(f); err != nil {
+						break
+					}
+					rec.Deck = append(rec.Deck, c)
+				}
+			default:
+				err = fmt.Errorf("Unknown header %q", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
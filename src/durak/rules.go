@@ -59,14 +59,31 @@ func (card Card) Beats(other Card, trump Card) bool {
     return card.Rank() > other.Rank() && card.Suit() == other.Suit()
 }
 
+// 36 cards from sixes up, or 24 from nines up
+var DeckSizes = []int{36, 24}
+
 func GenerateDeck() []Card {
+	return GenerateDeckRand(nil)
+}
+
+// Shuffle with rng, or the global source if nil
+func GenerateDeckRand(rng *rand.Rand) []Card {
+	return GenerateDeckSizeRand(36, rng)
+}
+
+// The highest size/4 ranks of each suit
+func GenerateDeckSizeRand(size int, rng *rand.Rand) []Card {
     res := make([]Card, 0)
     for suit := 0; suit < 4; suit++ {
-        for rank := 0; rank < 9; rank++ {
+        for rank := 9 - size/4; rank < 9; rank++ {
             res = append(res, CardFromRankSuit(rank, suit))
         }
     }
-    rand.Shuffle(len(res), func(i, j int) {
+	shuffle := rand.Shuffle
+	if rng != nil {
+		shuffle = rng.Shuffle
+	}
+    shuffle(len(res), func(i, j int) {
         res[i], res[j] = res[j], res[i]
     })
     return res
@@ -105,6 +122,17 @@ func (a Action) ToStr() string {
     return string(jsn)
 }
 
+// Human-readable action
+func (a Action) Describe() string {
+	switch a.Verb {
+		case PlayVerb, ReverseVerb:
+			return fmt.Sprintf("%s %s", verbs[a.Verb], a.Card.ToStr())
+		case CoverVerb:
+			return fmt.Sprintf("Cover %s with %s", a.Covering.ToStr(), a.Card.ToStr())
+	}
+	return verbs[a.Verb]
+}
+
 type GameState struct {
     Attacker int
     Defender int
@@ -120,6 +148,8 @@ type GameState struct {
     Dir int
 	Deck []Card
 	CardsInDeck int
+	// Plain durak, the defender can't pass the attack on
+	NoReverse bool
 }
 
 func (state *GameState) NumCovered() int {
@@ -146,14 +176,32 @@ func LowestTrumpRank(trump Card, cards []Card) int {
 }
 
 func InitGameState(nPlayers int) *GameState {
-	if nPlayers < 2 || nPlayers > 6 {
+	return DealGameState(nPlayers, GenerateDeck())
+}
+
+// Same seed, same deal
+func InitGameStateSeed(nPlayers int, seed int64) *GameState {
+	return InitGameStateRand(nPlayers, rand.New(rand.NewSource(seed)))
+}
+
+func InitGameStateRand(nPlayers int, rng *rand.Rand) *GameState {
+	return DealGameState(nPlayers, GenerateDeckRand(rng))
+}
+
+// Deal from the top of an ordered deck, the last card is trump
+func DealGameState(nPlayers int, deck []Card) *GameState {
+	if nPlayers < 2 || nPlayers > 6 || len(deck) < 6*nPlayers {
 		return nil
 	}
-	deck := GenerateDeck()
-	// Generate UNK_DECK
-	for i := 0; i < len(deck); i++ {
-		UNK_DECK[i] = UNK_CARD
+	// Each card of the full deck at most once
+	seen := make([]bool, 36)
+	for _,c := range deck {
+		if c < 0 || int(c) >= len(seen) || seen[c] {
+			return nil
+		}
+		seen[c] = true
 	}
+	deck = append(make([]Card, 0), deck...)
 	// Deal deck to players
 	hands := make([][]Card, nPlayers)
 	known := make([][]Card, nPlayers)
@@ -272,7 +320,7 @@ func (state *GameState) DefenderActions(player int) []Action {
     }
     revRank := state.ReverseRank()
     // Only allow reverse when defender can potentially meet it
-    if revRank != -1 && state.NumCovered() == 0 && len(state.Plays)+1 <= len(state.Hands[state.Attacker]) {
+    if !state.NoReverse && revRank != -1 && state.NumCovered() == 0 && len(state.Plays)+1 <= len(state.Hands[state.Attacker]) {
         for _,card := range state.Hands[player] {
             if card.Rank() == revRank {
                 res = append(res, Action{player, ReverseVerb, card, NO_CARD})
@@ -341,7 +389,8 @@ func (state *GameState) IsOver() bool {
 			n++
 		}
     }
-    return n == len(state.Hands)-1
+    // Everyone out at once is a draw
+    return n >= len(state.Hands)-1
 }
 
 // This probably deals in correct order now
@@ -450,6 +499,13 @@ func (state *GameState) TakeAction(action Action) {
             state.Deferring = make([]bool, len(state.Hands))
             state.Passed = make([]bool, len(state.Hands))
 			state.Deal(defender)
+			// Whoever ran out of cards with nothing left to draw sits out
+			if !state.IsOver() && (state.Won[state.Attacker] || state.Won[state.Defender]) {
+				if state.Won[state.Attacker] {
+					state.Attacker = state.NextRole(state.Attacker)
+				}
+				state.Defender = state.NextRole(state.Attacker)
+			}
         }
         case DeferVerb: {
             state.Deferring[action.Player] = true
@@ -495,16 +551,20 @@ func (state *GameState) Clone() *GameState {
         Dir: state.Dir,
 		Deck: append(make([]Card, 0), state.Deck...),
 		CardsInDeck: state.CardsInDeck,
+		NoReverse: state.NoReverse,
     }
 }
 
-var UNK_DECK = make([]Card, 36)
-
 func (state *GameState) Mask(me int) {
-	// Mask deck
-	if state.CardsInDeck > 1 {
-		state.Deck = UNK_DECK
+	// Mask deck, dealt cards too, except the face-up trump at the bottom
+	deck := make([]Card, len(state.Deck))
+	for i := range deck {
+		deck[i] = UNK_CARD
+	}
+	if len(deck) > 0 {
+		deck[len(deck)-1] = state.Trump
 	}
+	state.Deck = deck
 	// Mask hands
 	if len(state.Hands) == 2 && state.CardsInDeck <= 1 {
 		return
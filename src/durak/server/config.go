@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/aorliche/cards-ai/durak/ai"
+	"github.com/aorliche/cards-ai/durak"
+)
+
+// Rule options sent as Data with New, every field optional
+type Config struct {
+	// Reverse, or NoReverse for plain durak
+	Rules string
+	// 36, or 24 for nines up
+	DeckSize int
+	// Plays for humans who leave
+	Bot string
+	// Milliseconds searching bots think per move, 0 for their own
+	Budget int64
+}
+
+// Longest a bot may think per move
+var MaxBudget int64 = 30000
+
+var DefaultConfig = Config{Rules: durak.DefaultRules, DeckSize: 36, Bot: "Medium"}
+
+// Defaults for missing fields, errors say what's wrong with the rest
+func ParseConfig(data string) (Config, error) {
+	config := DefaultConfig
+	if data == "" {
+		return config, nil
+	}
+	dec := json.NewDecoder(bytes.NewBufferString(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return config, fmt.Errorf("Bad Durak options: %v", err)
+	}
+	if config.Rules != durak.DefaultRules && config.Rules != durak.NoReverseRules {
+		return config, fmt.Errorf("Rules must be %s or %s, not %q", durak.DefaultRules, durak.NoReverseRules, config.Rules)
+	}
+	if !slices.Contains(durak.DeckSizes, config.DeckSize) {
+		return config, fmt.Errorf("Deck size must be one of %v, not %d", durak.DeckSizes, config.DeckSize)
+	}
+	if _, err := ai.BotByName(config.Bot); err != nil {
+		return config, fmt.Errorf("Bad takeover bot: %v", err)
+	}
+	if config.Budget < 0 || config.Budget > MaxBudget {
+		return config, fmt.Errorf("Budget must be from 0 to %d ms, not %d", MaxBudget, config.Budget)
+	}
+	return config, nil
+}
@@ -4,8 +4,13 @@ import(
 	"bytes"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -21,6 +26,13 @@ type GameState struct {
 	Player int
 	Names []string
 	Actions []durak.Action
+	// Deal seed to reproduce the game, only once it's over
+	// since it rebuilds every hidden hand
+	Seed int64
+	// Only set when replaying a finished game
+	Replay *server.ReplayPos
+	// Only set for spectators
+	Spectator *server.SpectatorView
 }
 
 type Game struct {
@@ -29,15 +41,64 @@ type Game struct {
 	Players []*server.Player
 	// The actual game state
 	State *GameState
+	Record *durak.Record
 	Terminated bool
+	Config Config
+	// Closed to stop the bot playing a seat
+	stops []chan struct{}
+	// Only for exhibitions
+	pacer *server.Pacer
+}
+
+// Directory for finished game records, none saved if empty
+var recordDir = ""
+
+// Every move goes through here to be recorded
+func (game *Game) takeAction(act durak.Action) {
+	game.Record.TakeAction(&game.State.GameState.GameState, act)
+	log.Println(game.State.CardsInDeck, act.Notation())
+	if game.State.IsOver() && recordDir != "" {
+		game.saveRecord()
+	}
+}
+
+// Humans by name, bots by type
+func (game *Game) names() []string {
+	names := make([]string, len(game.Players))
+	for i,p := range game.Players {
+		if p.Type == "Human" {
+			names[i] = p.Name
+		} else {
+			names[i] = p.Type
+		}
+	}
+	return names
+}
+
+func (game *Game) saveRecord() {
+	// Humans may have joined after the deal
+	game.Record.Players = game.names()
+	f, err := os.Create(filepath.Join(recordDir, fmt.Sprintf("durak-%d.jsonl", game.Key)))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	if err := game.Record.WriteJSONL(f); err != nil {
+		log.Println(err)
+	}
 }
 
 func CreateGame() server.Game {
-	return &Game{Players: make([]*server.Player, 0)}
+	return &Game{Players: make([]*server.Player, 0), Config: DefaultConfig}
 }
 
+// Also takes every bot off the table
 func (game *Game) Terminate() {
 	game.Terminated = true
+	for i := range game.stops {
+		game.StopBot(i)
+	}
 }
 
 func (game *Game) GetKey() int {
@@ -57,10 +118,18 @@ func (game *Game) HasOpenSlots() bool {
 	return false
 }
 
+// Nothing is dealt while players wait in the room
 func (game *Game) IsOver() bool {
-	return game.State.IsOver() || game.Terminated
+	return game.Terminated || (game.State != nil && game.State.IsOver())
 }
 	
+// IsOver for bots, which run without the lock
+func (game *Game) over() bool {
+	game.Lock()
+	defer game.Unlock()
+	return game.IsOver()
+}
+
 func (game *Game) AddPlayer(player server.Player) {
 	game.Players = append(game.Players, &player)
 }
@@ -69,46 +138,145 @@ func (game *Game) GetPlayers() []*server.Player {
 	return game.Players
 }
 
-func (game *Game) Init(string) error {
+func (game *Game) Configure(data string) error {
+	config, err := ParseConfig(data)
+	if err != nil {
+		return err
+	}
+	game.Config = config
+	return nil
+}
+
+func (game *Game) Init(data string) error {
+	if err := game.Configure(data); err != nil {
+		return err
+	}
 	n := len(game.Players)
 	if n < 2 || n > 4 {
 		return errors.New("Bad number of players for Durak")
 	}
+	// Bots for non-human players
+	bots := make([]ai.Bot, n)
+	for i,p := range game.Players {
+		if p.Type == "Human" {
+			continue
+		}
+		bot, err := ai.BotWithBudget(p.Type, game.Config.Budget)
+		if err != nil {
+			return err
+		}
+		bots[i] = bot
+	}
 	// Horrible
-	game.State = &GameState{ai.GameState{*durak.InitGameState(n), nil}, 0, nil, nil}
-	// AI Logic
-	aiFunc := func (player int) {
-		for !game.IsOver() {
-			time.Sleep(200 * time.Millisecond)
-			game.Lock()
-			st := &ai.GameState{*game.State.Clone(), nil}
-			game.Unlock()
-			act, ok := st.FindBestAction(player, 12, 2000)
-			if !ok {
-				continue
-			}
-			game.Lock()
-			acts := game.State.PlayerActions(player)
-			for _,a := range acts {
-				if a == act {
-					game.State.TakeAction(act)
-					log.Println(game.State.CardsInDeck, act.ToStr())
-					server.UpdatePlayers(game)
+	seed := server.NewSeed()
+	deck := durak.GenerateDeckSizeRand(game.Config.DeckSize, rand.New(rand.NewSource(seed)))
+	game.State = &GameState{GameState: ai.GameState{GameState: *durak.DealGameState(n, deck)}}
+	game.State.NoReverse = game.Config.Rules == durak.NoReverseRules
+	game.Record = durak.NewRecord(&game.State.GameState.GameState, game.names())
+	game.Record.Seed = seed
+	// Start AI players
+	game.stops = make([]chan struct{}, n)
+	for i,bot := range bots {
+		if bot != nil {
+			game.startBot(i, bot)
+		}
+	}
+	return nil
+}
+
+// AI Logic, plays for player until the game ends or stop closes
+func (game *Game) runBot(player int, bot ai.Bot, stop chan struct{}) {
+	for !game.over() {
+		time.Sleep(200 * time.Millisecond)
+		if game.pacer != nil && !game.pacer.Ready() {
+			continue
+		}
+		game.Lock()
+		st := &ai.GameState{GameState: *game.State.Clone()}
+		game.Unlock()
+		select {
+			case <-stop:
+				return
+			default:
+		}
+		act, exp, ok := bot(st, player)
+		if !ok {
+			continue
+		}
+		game.Lock()
+		// Seat handed back while thinking
+		select {
+			case <-stop:
+				game.Unlock()
+				return
+			default:
+		}
+		acts := game.State.PlayerActions(player)
+		for _,a := range acts {
+			if a == act {
+				if game.pacer != nil && !game.pacer.Take() {
 					break
 				}
+				game.takeAction(act)
+				if exp != nil {
+					log.Println(exp.ToStr())
+				}
+				server.UpdatePlayers(game)
+				break
 			}
-			game.Unlock()
 		}
+		game.Unlock()
 	}
-	// Start AI players
-	for i,p := range game.Players {
-		if p.Type == "Medium" {
-			go aiFunc(i)
-		}
+}
+
+func (game *Game) startBot(seat int, bot ai.Bot) {
+	stop := make(chan struct{})
+	game.stops[seat] = stop
+	go game.runBot(seat, bot, stop)
+}
+
+// Bot plays a seat, the configured takeover bot if typ is empty
+func (game *Game) StartBot(seat int, typ string) error {
+	if typ == "" {
+		typ = game.Config.Bot
+	}
+	bot, err := ai.BotWithBudget(typ, game.Config.Budget)
+	if err != nil {
+		return err
+	}
+	if game.stops == nil {
+		return server.ErrNotStarted
 	}
+	if game.stops[seat] != nil {
+		return fmt.Errorf("Seat %d already has a bot", seat)
+	}
+	game.startBot(seat, bot)
 	return nil
 }
 
+func (game *Game) GetType() string {
+	return "Durak"
+}
+
+func (game *Game) GetOptions() any {
+	return game.Config
+}
+
+func (game *Game) Pace(pacer *server.Pacer) {
+	game.pacer = pacer
+}
+
+func (game *Game) StopBot(seat int) {
+	// Not dealt yet
+	if game.stops == nil {
+		return
+	}
+	if game.stops[seat] != nil {
+		close(game.stops[seat])
+		game.stops[seat] = nil
+	}
+}
+
 func (game *Game) Join(string) error {
 	return nil
 }
@@ -119,31 +287,109 @@ func (game *Game) Action(data string) error {
 	actions := game.State.PlayerActions(act.Player)
 	for _,a := range actions {
 		if a == act {
-			game.State.TakeAction(act)
+			game.takeAction(act)
 			return nil
 		}
 	}
 	return errors.New("Invalid action")
 }
 
+// Search from what player can see
+func (game *Game) Hint(player int) (string, error) {
+	game.Lock()
+	st := &ai.GameState{GameState: *game.State.Clone()}
+	game.Unlock()
+	act, e, ok := st.SuggestAction(player, 12, 1000)
+	if !ok {
+		return "", errors.New("Nothing to suggest right now")
+	}
+	reason := fmt.Sprintf("%s, search expects your hand %.0f ahead of the weakest", act.Describe(), e)
+	data, err := json.Marshal(server.Hint{Action: act, Reason: reason, Score: e})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 func (game *Game) GetState(player int) (string, error) {
 	sav := game.State.Clone()
 	game.State.Mask(player)
 	// Set player
 	game.State.Player = player
 	// Add player names
-	game.State.Names = make([]string, len(game.Players))
-	for i,p := range game.Players {
-		if p.Type == "Human" {
-			game.State.Names[i] = p.Name
-		} else {
-			game.State.Names[i] = p.Type
-		}
-	}
+	game.State.Names = game.names()
 	// Get player actions
 	game.State.Actions = game.State.PlayerActions(player)
+	game.State.Seed = game.seed()
 	data, err := json.Marshal(*game.State)
-	game.State.GameState = ai.GameState{*sav, nil}
+	game.State.GameState = ai.GameState{GameState: *sav}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Zero while any hand is still hidden
+func (game *Game) seed() int64 {
+	if game.State.IsOver() {
+		return game.Record.Seed
+	}
+	return 0
+}
+
+// Position after the first move actions from the game record
+func (game *Game) Replay(hand int, move int, seat int) (string, error) {
+	// Durak is always a single hand
+	if hand != 0 {
+		return "", fmt.Errorf("No hand %d", hand)
+	}
+	// Ended in the waiting room before the deal
+	if game.Record == nil || game.State == nil {
+		return "", server.ErrNotStarted
+	}
+	if seat < -1 || seat >= len(game.Players) {
+		return "", fmt.Errorf("No seat %d", seat)
+	}
+	st, err := game.Record.StateAt(move)
+	if err != nil {
+		return "", err
+	}
+	player := seat
+	if seat == -1 {
+		player = 0
+	} else {
+		st.Mask(seat)
+	}
+	state := GameState{
+		GameState: ai.GameState{GameState: *st},
+		Player: player,
+		Names: game.names(),
+		Actions: make([]durak.Action, 0),
+		Seed: game.Record.Seed,
+		Replay: &server.ReplayPos{Hand: 0, Hands: 1, Move: move, Moves: len(game.Record.Actions), Seat: seat},
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Table as spectators see it, from seat 0
+func (game *Game) Spectate(open bool) (string, error) {
+	st := game.State.Clone()
+	if !open {
+		st.Mask(-1)
+	}
+	state := GameState{
+		GameState: ai.GameState{GameState: *st},
+		Player: 0,
+		Names: game.names(),
+		Actions: make([]durak.Action, 0),
+		Seed: game.seed(),
+		Spectator: &server.SpectatorView{Open: open},
+	}
+	data, err := json.Marshal(state)
 	if err != nil {
 		return "", err
 	}
@@ -151,6 +397,11 @@ func (game *Game) GetState(player int) (string, error) {
 }
 
 func main() {
+    flag.StringVar(&recordDir, "records", "", "directory to save finished game records")
+    flag.BoolVar(&server.BotTakeover, "takeover", false, "let a bot play for disconnected humans")
+    flag.IntVar(&server.MaxGames, "maxgames", server.MaxGames, "most games in progress at once, 0 for no limit")
+    flag.DurationVar(&server.IdleTimeout, "idle", server.IdleTimeout, "abandon games with no moves for this long")
+    flag.Parse()
     log.SetFlags(0)
     server.ServeLocalFiles([]string{
 		"/home/anton/GitHub/cards-ai/static/cards/fronts",
@@ -169,6 +420,7 @@ func main() {
 		"/css",
 	})
 	server.CreateGameFunc = CreateGame
+	server.StartReaper(time.Minute)
     http.HandleFunc("/ws", server.Socket)
     log.Fatal(http.ListenAndServe(":8000", nil))
 }
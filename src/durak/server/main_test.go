@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/aorliche/cards-ai/server"
+)
+
+// Next reply of type typ, failing on errors
+func readReply(t *testing.T, conn *websocket.Conn, typ string) server.Reply {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5*time.Second))
+	for {
+		var reply server.Reply
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("Waiting for %s: %v", typ, err)
+		}
+		if reply.Type == typ {
+			return reply
+		}
+		if reply.Type == "Error" {
+			t.Fatalf("Waiting for %s: %s", typ, reply.Data)
+		}
+	}
+}
+
+// The waiting room through the real game, which has no state until the deal
+func TestWaitingRoomSocket(t *testing.T) {
+	server.CreateGameFunc = CreateGame
+	srv := httptest.NewServer(http.HandlerFunc(server.Socket))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	dial := func () *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func () { conn.Close() })
+		return conn
+	}
+	a, b := dial(), dial()
+	a.WriteJSON(server.Request{Type: "New", Name: "A", Types: []string{"Human", "Human", "Random"}, Data: `{"DeckSize": 24}`})
+	var key int
+	json.Unmarshal([]byte(readReply(t, a, "New").Data), &key)
+	readReply(t, a, "Room")
+	a.WriteJSON(server.Request{Type: "Hint", Game: key})
+	readReply(t, a, "Error")
+	b.WriteJSON(server.Request{Type: "Join", Game: key, Name: "B"})
+	readReply(t, b, "Room")
+	a.WriteJSON(server.Request{Type: "Start"})
+	var st GameState
+	json.Unmarshal([]byte(readReply(t, b, "Update").Data), &st)
+	if st.Player != 1 || st.CardsInDeck != 24-18 || st.Seed != 0 {
+		t.Errorf("Bad first update %+v", st)
+	}
+}
+
+// Everyone left before the deal, so there's nothing to list or replay
+func TestEmptyRoomReplay(t *testing.T) {
+	server.CreateGameFunc = CreateGame
+	srv := httptest.NewServer(http.HandlerFunc(server.Socket))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	dial := func () *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func () { conn.Close() })
+		return conn
+	}
+	a, b := dial(), dial()
+	a.WriteJSON(server.Request{Type: "New", Name: "A", Types: []string{"Human", "Human"}})
+	var key int
+	json.Unmarshal([]byte(readReply(t, a, "New").Data), &key)
+	readReply(t, a, "Room")
+	a.Close()
+	// Wait for the game to leave the lobby
+	for deadline := time.Now().Add(5*time.Second); ; {
+		b.WriteJSON(server.Request{Type: "List"})
+		var lobby []server.Listing
+		json.Unmarshal([]byte(readReply(t, b, "List").Data), &lobby)
+		if !slices.ContainsFunc(lobby, func (lst server.Listing) bool { return lst.Key == key }) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Game still listed %+v", lobby)
+		}
+		time.Sleep(10*time.Millisecond)
+	}
+	b.WriteJSON(server.Request{Type: "Finished"})
+	var keys []int
+	json.Unmarshal([]byte(readReply(t, b, "Finished").Data), &keys)
+	if slices.Contains(keys, key) {
+		t.Errorf("Undealt game %d listed as finished %v", key, keys)
+	}
+	b.WriteJSON(server.Request{Type: "Replay", Game: key, Data: `{"Hand": 0, "Move": 0, "Seat": -1}`})
+	var msg string
+	json.Unmarshal([]byte(readReply(t, b, "Error").Data), &msg)
+	if msg != server.ErrNotStarted.Error() {
+		t.Errorf("Bad replay error %q", msg)
+	}
+	// The game lock was released
+	b.WriteJSON(server.Request{Type: "List"})
+	readReply(t, b, "List")
+}
@@ -3,7 +3,8 @@ module github.com/aorliche/cards-ai
 go 1.22.5
 
 require (
-	github.com/google/go-cmp v0.5.9 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
-	gotest.tools/v3 v3.5.1 // indirect
+	github.com/gorilla/websocket v1.5.3
+	gotest.tools/v3 v3.5.1
 )
+
+require github.com/google/go-cmp v0.5.9 // indirect
@@ -3,6 +3,7 @@ package search
 import (
 	//"log"
 	"math"
+	"sort"
 	"time"
 
 	//"github.com/aorliche/cards-ai/durak"
@@ -90,3 +91,42 @@ func Search(state GameState, player int, depth int, startTime time.Time, timeBud
 	}
 	return bestAction, bestState, ns, false
 }
+
+// Root action with its score and the position search expects after it
+type Candidate struct {
+	Action Action
+	Score float64
+	State GameState
+}
+
+// Iterative deepening like SearchItDeep
+// Keeps every root action's score from the deepest search that finished
+// Best candidate first
+func SearchCandidates(state GameState, player int, depth int, timeBudget int64) []Candidate {
+	startTime := time.Now()
+	best := make([]Candidate, 0)
+	actions, states := state.Children(player)
+	for d := 1; d < depth; d++ {
+		cands := make([]Candidate, 0, len(actions))
+		timedOut := false
+		for j := 0; j < len(actions); j++ {
+			_, s, _, to := Search(states[j], player, d-1, startTime, timeBudget, false)
+			if to {
+				timedOut = true
+				break
+			}
+			if s == nil {
+				continue
+			}
+			cands = append(cands, Candidate{actions[j], s.Eval(player), s})
+		}
+		if timedOut {
+			break
+		}
+		sort.SliceStable(cands, func (a int, b int) bool {
+			return cands[a].Score > cands[b].Score
+		})
+		best = cands
+	}
+	return best
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"log"
+	"sync"
+
+    "github.com/gorilla/websocket"
+)
+
+// Messages waiting for a slow client before it's dropped
+var QueueSize = 256
+
+// Websocket with a single writer goroutine
+// gorilla/websocket allows only one concurrent writer per connection,
+// while bots, request handlers and timers all send updates
+type Conn struct {
+	ws *websocket.Conn
+	out chan []byte
+	done chan struct{}
+	closeOnce sync.Once
+	// Closed to flush the queue and then close
+	finish chan struct{}
+	finishOnce sync.Once
+}
+
+func NewConn(ws *websocket.Conn) *Conn {
+	conn := &Conn{ws: ws, out: make(chan []byte, QueueSize),
+		done: make(chan struct{}), finish: make(chan struct{})}
+	go conn.writer()
+	return conn
+}
+
+func (conn *Conn) writer() {
+	for {
+		select {
+			case msg := <-conn.out:
+				if err := conn.ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+					log.Println(err)
+					conn.Close()
+					return
+				}
+			case <-conn.finish:
+				conn.flush()
+				conn.Close()
+				return
+			case <-conn.done:
+				return
+		}
+	}
+}
+
+// Write whatever is still queued
+func (conn *Conn) flush() {
+	for {
+		select {
+			case msg := <-conn.out:
+				if err := conn.ws.WriteMessage(websocket.TextMessage, msg); err != nil {
+					return
+				}
+			default:
+				return
+		}
+	}
+}
+
+// Queue a message without blocking
+// A client that can't keep up is disconnected
+func (conn *Conn) Send(msg []byte) {
+	select {
+		case <-conn.done:
+			return
+		case <-conn.finish:
+			return
+		default:
+	}
+	select {
+		case conn.out <- msg:
+		default:
+			log.Println("Outbound queue full, dropping connection")
+			conn.Close()
+	}
+}
+
+// Also ends the reader, which sees the socket closed
+func (conn *Conn) Close() {
+	conn.closeOnce.Do(func () {
+		close(conn.done)
+		conn.ws.Close()
+	})
+}
+
+// Close once everything already sent has been written
+func (conn *Conn) Finish() {
+	conn.finishOnce.Do(func () {
+		close(conn.finish)
+	})
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Sent with an Exhibition request
+// Delay is milliseconds between bot moves
+type Exhibition struct {
+	Delay int
+}
+
+// Longest wait between exhibition moves
+var MaxDelay = 10 * time.Second
+
+var ErrBotsOnly = errors.New("Exhibition games are for bots only")
+
+// Whether the watcher has paused the bots
+type PaceState struct {
+	Paused bool
+}
+
+// Spaces out bot moves and lets a watcher pause and step through them
+// Bots check Ready before thinking and Take before moving
+type Pacer struct {
+	sync.Mutex
+	delay time.Duration
+	paused bool
+	steps int
+	last time.Time
+}
+
+func NewPacer(delay time.Duration) *Pacer {
+	return &Pacer{delay: delay}
+}
+
+// Worth thinking about a move
+func (pacer *Pacer) Ready() bool {
+	pacer.Lock()
+	defer pacer.Unlock()
+	if pacer.paused {
+		return pacer.steps > 0
+	}
+	return time.Since(pacer.last) >= pacer.delay
+}
+
+// Use up the go-ahead for one move, false if there isn't one
+// Call with the game locked so two bots can't share a step
+func (pacer *Pacer) Take() bool {
+	pacer.Lock()
+	defer pacer.Unlock()
+	if pacer.paused {
+		if pacer.steps == 0 {
+			return false
+		}
+		pacer.steps--
+	} else if time.Since(pacer.last) < pacer.delay {
+		return false
+	}
+	pacer.last = time.Now()
+	return true
+}
+
+func (pacer *Pacer) Pause() {
+	pacer.Lock()
+	defer pacer.Unlock()
+	pacer.paused = true
+	pacer.steps = 0
+}
+
+// One more move while paused
+func (pacer *Pacer) Step() {
+	pacer.Lock()
+	defer pacer.Unlock()
+	if pacer.paused {
+		pacer.steps++
+	}
+}
+
+func (pacer *Pacer) Resume() {
+	pacer.Lock()
+	defer pacer.Unlock()
+	pacer.paused = false
+	pacer.steps = 0
+}
+
+func (pacer *Pacer) State() PaceState {
+	pacer.Lock()
+	defer pacer.Unlock()
+	return PaceState{Paused: pacer.paused}
+}
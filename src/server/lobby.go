@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Who sits where, for the lobby
+type Seat struct {
+	Name string
+	Type string
+	Joined bool
+}
+
+// Lobby entry for a game
+type Listing struct {
+	Key int
+	// e.g. Durak or Spades
+	Game string
+	Options any
+	Players []Seat
+	OpenSeats []int
+	Spectators int
+	Created time.Time
+	Status Status
+}
+
+func (status Status) MarshalText() ([]byte, error) {
+	return []byte(status.String()), nil
+}
+
+func (status *Status) UnmarshalText(text []byte) error {
+	i := slices.Index(statuses, string(text))
+	if i == -1 {
+		return fmt.Errorf("Unknown status %s", text)
+	}
+	*status = Status(i)
+	return nil
+}
+
+// Call with the game locked
+func (reg *Registry) listing(game Game) (Listing, bool) {
+	reg.Lock()
+	defer reg.Unlock()
+	ent := reg.games[game.GetKey()]
+	if ent == nil {
+		return Listing{}, false
+	}
+	reg.update(ent)
+	lst := Listing{
+		Key: game.GetKey(),
+		Game: game.GetType(),
+		Options: game.GetOptions(),
+		Players: make([]Seat, 0),
+		OpenSeats: make([]int, 0),
+		Spectators: len(ent.spectators),
+		Created: ent.created,
+		Status: ent.status,
+	}
+	for i,p := range game.GetPlayers() {
+		lst.Players = append(lst.Players, Seat{Name: p.Name, Type: p.Type, Joined: p.Joined})
+		if !p.Joined {
+			lst.OpenSeats = append(lst.OpenSeats, i)
+		}
+	}
+	return lst, true
+}
+
+// Public waiting and playing games, by key
+func (reg *Registry) Lobby() []Listing {
+	lobby := make([]Listing, 0)
+	for _,game := range reg.snapshot(true) {
+		game.Lock()
+		lst, ok := reg.listing(game)
+		game.Unlock()
+		if ok && lst.Status.Live() {
+			lobby = append(lobby, lst)
+		}
+	}
+	sort.Slice(lobby, func (i, j int) bool { return lobby[i].Key < lobby[j].Key })
+	return lobby
+}
+
+func lobbyReply(lobby []Listing) []byte {
+	jsn, _ := json.Marshal(lobby)
+	reply := Reply{Type: "List", Data: string(jsn)}
+	repJsn, _ := json.Marshal(reply)
+	return repJsn
+}
+
+// Push the lobby to conn whenever it changes
+func (reg *Registry) Subscribe(conn *Conn) {
+	reg.Lock()
+	reg.subscribers[conn] = true
+	reg.Unlock()
+	reg.changed()
+}
+
+func (reg *Registry) Unsubscribe(conn *Conn) {
+	reg.Lock()
+	defer reg.Unlock()
+	delete(reg.subscribers, conn)
+}
+
+// Safe to call with anything locked, the lobby is sent later
+func (reg *Registry) changed() {
+	select {
+		case reg.dirty <- struct{}{}:
+		default:
+	}
+}
+
+// Changes that happen in bursts go out as one update
+func (reg *Registry) pushLobby() {
+	for range reg.dirty {
+		reg.Lock()
+		n := len(reg.subscribers)
+		reg.Unlock()
+		if n == 0 {
+			continue
+		}
+		msg := lobbyReply(reg.Lobby())
+		reg.Lock()
+		for conn := range reg.subscribers {
+			conn.Send(msg)
+		}
+		reg.Unlock()
+	}
+}
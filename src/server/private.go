@@ -0,0 +1,37 @@
+package server
+
+import (
+	crand "crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+)
+
+var ErrWrongCode = errors.New("Private game, wrong password or invite code")
+
+// Sent to the creator of a private game to pass on
+type Invite struct {
+	Game int
+	Code string
+}
+
+// Short enough to read out, long enough not to guess
+func NewInviteCode() string {
+	b := make([]byte, 5)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Nil for public games or the right code
+func (reg *Registry) CheckCode(key int, code string) error {
+	reg.Lock()
+	defer reg.Unlock()
+	ent := reg.games[key]
+	if ent == nil || ent.code == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(ent.code), []byte(code)) != 1 {
+		return ErrWrongCode
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Status int
+
+const (
+	// Players gathering before the deal
+	Waiting Status = iota
+	Playing
+	Finished
+	// Ended early, e.g. a player never came back or nobody moved
+	Abandoned
+)
+
+var statuses = []string{"Waiting", "Playing", "Finished", "Abandoned"}
+
+func (status Status) String() string {
+	return statuses[status]
+}
+
+func (status Status) Live() bool {
+	return status == Waiting || status == Playing
+}
+
+// Most waiting or playing games at once, no limit if zero
+var MaxGames = 100
+
+// Live games with no moves for this long are abandoned
+var IdleTimeout = 30 * time.Minute
+
+// Finished and abandoned games stay around this long for replays
+var FinishedRetention = time.Hour
+
+var ErrTooManyGames = errors.New("Too many games in progress, try again later")
+
+type entry struct {
+	game Game
+	created time.Time
+	active time.Time
+	status Status
+	spectators []*Spectator
+	// Needed to join a private game, empty for public ones
+	code string
+	// Waiting room until the host starts the game with config
+	room bool
+	host *Player
+	config string
+	// Who asked for whose seat
+	swaps map[*Player]*Player
+}
+
+// Games by key, safe for every socket goroutine
+// Lock order is game before registry, never the other way around
+type Registry struct {
+	sync.Mutex
+	games map[int]*entry
+	next int
+	// Reserved keys plus waiting and playing games
+	live int
+	// Lobby subscriptions, pushed to when dirty
+	subscribers map[*Conn]bool
+	dirty chan struct{}
+}
+
+func NewRegistry() *Registry {
+	reg := &Registry{
+		games: make(map[int]*entry),
+		subscribers: make(map[*Conn]bool),
+		dirty: make(chan struct{}, 1),
+	}
+	go reg.pushLobby()
+	return reg
+}
+
+// Reserve a key for a new game, keys are never reused
+// Call Release if the game doesn't get added
+func (reg *Registry) NextKey() (int, error) {
+	reg.Lock()
+	defer reg.Unlock()
+	if MaxGames > 0 && reg.live >= MaxGames {
+		return -1, ErrTooManyGames
+	}
+	key := reg.next
+	reg.next++
+	reg.live++
+	return key, nil
+}
+
+func (reg *Registry) Release() {
+	reg.Lock()
+	defer reg.Unlock()
+	reg.live--
+}
+
+// Private if code isn't empty, call with the game locked
+func (reg *Registry) Add(game Game, code string) {
+	reg.Lock()
+	defer reg.Unlock()
+	now := time.Now()
+	ent := &entry{game: game, created: now, active: now, status: Waiting, code: code}
+	reg.games[game.GetKey()] = ent
+	reg.update(ent)
+	reg.changed()
+}
+
+// Nil if there's no such game
+func (reg *Registry) Get(key int) Game {
+	reg.Lock()
+	defer reg.Unlock()
+	if ent := reg.games[key]; ent != nil {
+		return ent.game
+	}
+	return nil
+}
+
+func (reg *Registry) Remove(key int) {
+	reg.Lock()
+	defer reg.Unlock()
+	if ent := reg.games[key]; ent != nil {
+		if ent.status.Live() {
+			reg.live--
+		}
+		delete(reg.games, key)
+		reg.changed()
+	}
+}
+
+func (reg *Registry) Len() int {
+	reg.Lock()
+	defer reg.Unlock()
+	return len(reg.games)
+}
+
+func (reg *Registry) Status(key int) (Status, bool) {
+	reg.Lock()
+	defer reg.Unlock()
+	if ent := reg.games[key]; ent != nil {
+		return ent.status, true
+	}
+	return Waiting, false
+}
+
+// Status follows the game until it's over
+func (reg *Registry) update(ent *entry) {
+	if !ent.status.Live() {
+		return
+	}
+	status := Playing
+	switch {
+		case ent.game.IsOver(): status = Finished
+		case ent.room: status = Waiting
+	}
+	if !status.Live() {
+		reg.live--
+	}
+	if status != ent.status {
+		reg.changed()
+	}
+	ent.status = status
+}
+
+// Record a move or a join, call with the game locked
+func (reg *Registry) Touch(game Game) {
+	reg.Lock()
+	defer reg.Unlock()
+	if ent := reg.games[game.GetKey()]; ent != nil {
+		ent.active = time.Now()
+		reg.update(ent)
+	}
+}
+
+// Call with the game locked
+func (reg *Registry) Abandon(game Game) {
+	reg.Lock()
+	defer reg.Unlock()
+	if ent := reg.games[game.GetKey()]; ent != nil && ent.status.Live() {
+		ent.status = Abandoned
+		ent.active = time.Now()
+		reg.live--
+		reg.changed()
+	}
+}
+
+// Sorted keys of public games for which keep returns true
+// keep is called with the game locked
+func (reg *Registry) Keys(keep func(Game) bool) []int {
+	keys := make([]int, 0)
+	for _,game := range reg.snapshot(true) {
+		game.Lock()
+		ok := keep(game)
+		game.Unlock()
+		if ok {
+			keys = append(keys, game.GetKey())
+		}
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// Every game, or only the public ones
+func (reg *Registry) snapshot(public bool) []Game {
+	reg.Lock()
+	defer reg.Unlock()
+	all := make([]Game, 0, len(reg.games))
+	for _,ent := range reg.games {
+		if !public || ent.code == "" {
+			all = append(all, ent.game)
+		}
+	}
+	return all
+}
+
+// End a game for everyone and take its bots off
+// Call with the game locked
+func endGame(game Game, reason string) {
+	log.Println("Ending game", game.GetKey(), reason)
+	serverChat(game, reason)
+	game.Terminate()
+	for i := range game.GetPlayers() {
+		game.StopBot(i)
+	}
+	games.Abandon(game)
+	for _,p := range game.GetPlayers() {
+		if p.Conn != nil {
+			p.Conn.Finish()
+		}
+	}
+	for _,sp := range games.Spectators(game) {
+		sp.Conn.Finish()
+	}
+}
+
+// Abandon idle games and evict old finished ones
+func (reg *Registry) Reap() {
+	now := time.Now()
+	for _,game := range reg.snapshot(false) {
+		game.Lock()
+		reg.Lock()
+		ent := reg.games[game.GetKey()]
+		reg.update(ent)
+		status, idle := ent.status, now.Sub(ent.active)
+		reg.Unlock()
+		if status.Live() && idle > IdleTimeout {
+			endGame(game, "Game ended after no moves for " + IdleTimeout.String())
+		} else if !status.Live() && idle > FinishedRetention {
+			for i := range game.GetPlayers() {
+				game.StopBot(i)
+			}
+			reg.Remove(game.GetKey())
+		}
+		game.Unlock()
+	}
+}
+
+// Reap games every interval for the life of the server
+func StartReaper(interval time.Duration) {
+	go func () {
+		for range time.Tick(interval) {
+			games.Reap()
+		}
+	}()
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// Sent instead of updates while players gather in a waiting room
+type Room struct {
+	Game int
+	Seats []Seat
+	Host int
+	// Seat of whoever gets this, -1 for spectators
+	You int
+}
+
+var ErrNotHost = errors.New("Only the host can start the game")
+var ErrStarted = errors.New("Game has already started")
+var ErrNotStarted = errors.New("Game hasn't started")
+
+// Games with more than one human seat wait for the host to start them
+func needsRoom(types []string) bool {
+	humans := 0
+	for _,typ := range types {
+		if typ == "Human" {
+			humans++
+		}
+	}
+	return humans > 1
+}
+
+// Hold Init until the host starts the game
+// Call with the game locked, after Add
+func (reg *Registry) OpenRoom(game Game, host *Player, config string) {
+	reg.Lock()
+	defer reg.Unlock()
+	if ent := reg.games[game.GetKey()]; ent != nil {
+		ent.room = true
+		ent.host = host
+		ent.config = config
+		ent.swaps = make(map[*Player]*Player)
+	}
+}
+
+// Still in the waiting room, call with the game locked
+func (reg *Registry) InRoom(game Game) bool {
+	reg.Lock()
+	defer reg.Unlock()
+	ent := reg.games[game.GetKey()]
+	return ent != nil && ent.room
+}
+
+// Call with the game locked
+func (reg *Registry) room(game Game) *entry {
+	reg.Lock()
+	defer reg.Unlock()
+	if ent := reg.games[game.GetKey()]; ent != nil && ent.room {
+		return ent
+	}
+	return nil
+}
+
+// Human seat holding conn, -1 if none
+// Seats move in the waiting room, so sockets look theirs up each time
+func seatOf(game Game, conn *Conn) int {
+	game.Lock()
+	defer game.Unlock()
+	for i,p := range game.GetPlayers() {
+		if p.Type == "Human" && p.Conn == conn {
+			return i
+		}
+	}
+	return -1
+}
+
+// Tell a human which seat their token now belongs to
+// Call with the game locked
+func sendSession(game Game, seat int) {
+	p := game.GetPlayers()[seat]
+	jsn, _ := json.Marshal(Session{Game: game.GetKey(), Seat: seat, Token: p.Token})
+	reply := Reply{Type: "Session", Data: string(jsn)}
+	repJsn, _ := json.Marshal(reply)
+	p.Conn.Send(repJsn)
+}
+
+// Show the waiting room to everyone in it
+// Call with the game locked
+func updateRoom(game Game) {
+	ent := games.room(game)
+	if ent == nil {
+		return
+	}
+	room := Room{Game: game.GetKey(), Seats: make([]Seat, 0), Host: -1}
+	for i,p := range game.GetPlayers() {
+		room.Seats = append(room.Seats, Seat{Name: p.Name, Type: p.Type, Joined: p.Joined})
+		if p == ent.host {
+			room.Host = i
+		}
+	}
+	send := func (conn *Conn, seat int) {
+		room.You = seat
+		jsn, _ := json.Marshal(room)
+		reply := Reply{Type: "Room", Data: string(jsn)}
+		repJsn, _ := json.Marshal(reply)
+		conn.Send(repJsn)
+	}
+	for i,p := range game.GetPlayers() {
+		if p.Type == "Human" && p.Conn != nil {
+			send(p.Conn, i)
+		}
+	}
+	for _,sp := range games.Spectators(game) {
+		send(sp.Conn, -1)
+	}
+}
+
+// Sit a new human at seat, or the first open seat if nil
+// A bot in the wanted seat moves to an open one
+// Call with the game locked
+func joinSeat(game Game, seat *int, name string, conn *Conn) (int, error) {
+	players := game.GetPlayers()
+	open := -1
+	for i,p := range players {
+		if p.Type == "Human" && !p.Joined {
+			open = i
+			break
+		}
+	}
+	if open == -1 {
+		return -1, errors.New("No open seats")
+	}
+	if seat != nil {
+		want := *seat
+		if want < 0 || want >= len(players) {
+			return -1, fmt.Errorf("No seat %d", want)
+		}
+		if players[want].Type == "Human" && players[want].Joined {
+			return -1, fmt.Errorf("Seat %d is taken", want)
+		}
+		if players[want].Type != "Human" {
+			if !games.InRoom(game) {
+				return -1, ErrStarted
+			}
+			players[open], players[want] = players[want], players[open]
+		}
+		open = want
+	}
+	p := players[open]
+	p.Name = name
+	p.Joined = true
+	p.Conn = conn
+	return open, nil
+}
+
+// Seat order and who sat where, to put back if the game turns a join down
+type seating struct {
+	order []*Player
+	players []Player
+}
+
+// Call with the game locked
+func saveSeating(game Game) seating {
+	sav := seating{order: slices.Clone(game.GetPlayers())}
+	for _,p := range sav.order {
+		sav.players = append(sav.players, *p)
+	}
+	return sav
+}
+
+// Call with the game locked
+func (sav seating) restore(game Game) {
+	players := game.GetPlayers()
+	copy(players, sav.order)
+	for i,p := range players {
+		*p = sav.players[i]
+	}
+}
+
+// Move to an empty or bot seat, or swap once the human there asks for ours
+// Call with the game locked
+func requestSeat(game Game, seat int, want int) error {
+	ent := games.room(game)
+	if ent == nil {
+		return ErrStarted
+	}
+	players := game.GetPlayers()
+	if want < 0 || want >= len(players) {
+		return fmt.Errorf("No seat %d", want)
+	}
+	if want == seat {
+		return nil
+	}
+	me, them := players[seat], players[want]
+	if them.Type == "Human" && them.Joined {
+		games.Lock()
+		agreed := ent.swaps[them] == me
+		if !agreed {
+			ent.swaps[me] = them
+		}
+		games.Unlock()
+		if !agreed {
+			serverChat(game, fmt.Sprintf("%s would like to swap seats with %s", me.Name, them.Name))
+			return nil
+		}
+	}
+	games.Lock()
+	delete(ent.swaps, me)
+	delete(ent.swaps, them)
+	games.Unlock()
+	players[seat], players[want] = them, me
+	for _,i := range []int{seat, want} {
+		if players[i].Type == "Human" && players[i].Conn != nil {
+			sendSession(game, i)
+		}
+	}
+	updateRoom(game)
+	games.changed()
+	return nil
+}
+
+// Free the seat of a human who left the waiting room
+// The game ends if nobody is left to start it
+// Call with the game locked
+func leaveRoom(game Game, seat int) {
+	ent := games.room(game)
+	p := game.GetPlayers()[seat]
+	log.Println(p.Name, "left the waiting room of game", game.GetKey())
+	name := p.Name
+	*p = Player{Type: "Human"}
+	games.Lock()
+	for who, whom := range ent.swaps {
+		if who == p || whom == p {
+			delete(ent.swaps, who)
+		}
+	}
+	// Hand the room to someone still here
+	if ent.host == p {
+		ent.host = nil
+		for _,q := range game.GetPlayers() {
+			if q.Type == "Human" && q.Joined {
+				ent.host = q
+				break
+			}
+		}
+	}
+	host := ent.host
+	games.Unlock()
+	if host == nil {
+		endGame(game, "Everyone left the waiting room")
+		return
+	}
+	serverChat(game, fmt.Sprintf("%s left, %s is the host", name, host.Name))
+	updateRoom(game)
+	games.changed()
+}
+
+// Deal and start the bots, only the host can and only once every seat is taken
+// Call with the game locked
+func startRoom(game Game, seat int) error {
+	ent := games.room(game)
+	if ent == nil {
+		return ErrStarted
+	}
+	if seat == -1 || game.GetPlayers()[seat] != ent.host {
+		return ErrNotHost
+	}
+	if game.HasOpenSlots() {
+		return errors.New("Waiting for every seat to be taken")
+	}
+	// Nothing left to wait for if the rules reject the table
+	if err := game.Init(ent.config); err != nil {
+		endGame(game, "Game couldn't start: " + err.Error())
+		return err
+	}
+	games.Lock()
+	ent.room = false
+	ent.swaps = nil
+	games.update(ent)
+	games.Unlock()
+	games.changed()
+	return nil
+}
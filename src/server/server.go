@@ -3,10 +3,14 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand/v2"
 	"net/http"
 	"os"
-	"sort"
+	"slices"
+	"time"
 
     "github.com/gorilla/websocket"
 )
@@ -18,6 +22,12 @@ type Request struct {
    Types []string
    // Json
    Data string
+   // New makes a private game, with Code as the password if set
+   Private bool
+   // Password or invite code of a private game
+   Code string
+   // Seat to join, the first open one if nil
+   Seat *int
 }
 
 type Reply struct {
@@ -29,13 +39,43 @@ type Reply struct {
 type Chat struct {
 	Name string
 	Message string
+	Spectator bool
+}
+
+// Suggested move for a player
+type Hint struct {
+	Action any
+	Reason string
+	Score float64
+}
+
+// Replay position, sent along with the state
+// Seat -1 shows every hand
+type ReplayPos struct {
+	// Games of several hands are replayed one hand at a time
+	Hand int
+	Hands int
+	Move int
+	Moves int
+	Seat int
+}
+
+// Sent along with the state to spectators
+type SpectatorView struct {
+	Open bool
 }
 
 type Player struct {
 	Name string
 	Type string
 	Joined bool
-	Conn *websocket.Conn
+	Conn *Conn
+	// Reclaims the seat after a dropped connection
+	Token string
+	// When the connection dropped, zero while connected
+	Left time.Time
+	// Lets spectators see every hand
+	OpenTable bool
 }
 
 type Game interface {
@@ -48,35 +88,63 @@ type Game interface {
 	Unlock()
 	AddPlayer(Player)
 	GetPlayers() []*Player
+	// Deal with the rule options sent as Data with New
 	Init(string) error
+	// Check and keep the rule options without dealing, Init does the same
+	Configure(string) error
 	Join(string) error
 	Action(string) error
 	// Update info for player n
 	GetState(int) (string, error)
-	// Terminate game on player disconnect
+	// Json Hint for player n, locks the game itself since AI search is slow
+	Hint(int) (string, error)
+	// Update info after the first n moves of a hand as seen from a seat, or -1 for all hands
+	Replay(int, int, int) (string, error)
+	// Update info for spectators, with every hand or none
+	Spectate(bool) (string, error)
+	// Let a bot of the given type play a seat, the game's default if empty
+	StartBot(int, string) error
+	// Hand the seat back
+	StopBot(int)
+	// Space out bot moves, called before Init
+	Pace(*Pacer)
+	// Name of the game for the lobby, e.g. Durak
+	GetType() string
+	// Rule options for the lobby
+	GetOptions() any
+	// End the game, e.g. when a dropped player never returns
 	Terminate()
 }
 
+// Random nonzero seed for dealing, zero means unseeded in records
+// Kept under 2^53 so javascript clients see it exactly
+func NewSeed() int64 {
+	return rand.Int64N(1<<53 - 1) + 1
+}
+
 // Hack
 var CreateGameFunc func() Game
 
-var games = make(map[int]Game)
+var games = NewRegistry()
 var upgrader = websocket.Upgrader{} // Default options
 
-func NextGameIdx() int {
-    max := -1
-    for key := range games {
-        if key > max {
-            max = key
-        }
-    }
-    return max+1
+// IsOver with the game locked
+func isOver(game Game) bool {
+	game.Lock()
+	defer game.Unlock()
+	return game.IsOver()
 }
 
 // Update all human clients
 // e.g., in response to an AI move
 // Don't lock in update
 func UpdatePlayers(game Game) {
+	games.Touch(game)
+	// Nothing dealt yet
+	if games.InRoom(game) {
+		updateRoom(game)
+		return
+	}
 	for i,p := range game.GetPlayers() {
 		if p.Conn == nil {
 			continue
@@ -88,33 +156,59 @@ func UpdatePlayers(game Game) {
 		}
 		reply := Reply{Type: "Update", Data: state}
 		jsn, _ := json.Marshal(reply)
-		p.Conn.WriteMessage(websocket.TextMessage, jsn);
+		p.Conn.Send(jsn)
 	}
+	updateSpectators(game)
+}
+
+func sendError(conn *Conn, err error) {
+	jsn, _ := json.Marshal(err.Error())
+	reply := Reply{Type: "Error", Data: string(jsn)}
+	repJsn, _ := json.Marshal(reply)
+	conn.Send(repJsn)
 }
 
 func Socket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	conn := NewConn(ws)
 	defer conn.Close()
 	var socketGame Game
 	player := -1
+	// Game being watched, if any, and the name to chat under
+	var watching Game
+	var watchName string
+	// Set when hosting an exhibition
+	var pacer *Pacer
 	for {
-		msgType, msg, err := conn.ReadMessage()
+		msgType, msg, err := ws.ReadMessage()
 		if err != nil {
 			log.Println(err)
+			games.Unsubscribe(conn)
+			if socketGame != nil {
+				player = seatOf(socketGame, conn)
+			}
 			if socketGame != nil && player != -1 {
-				socketGame.Terminate()
-				for _,p := range socketGame.GetPlayers() {
-					if p.Conn != nil {
-						p.Conn.Close()
-					}
+				disconnect(socketGame, player, conn)
+			}
+			if watching != nil {
+				watching.Lock()
+				games.RemoveSpectator(watching, conn)
+				// Nobody left to pause or step the bots
+				if pacer != nil && !watching.IsOver() {
+					endGame(watching, "Exhibition host left")
 				}
+				watching.Unlock()
 			}
 			return  
 		}
+		// Seats can move in the waiting room
+		if socketGame != nil {
+			player = seatOf(socketGame, conn)
+		}
 		// Do we ever get any other types of messages?
 		if msgType != websocket.TextMessage {
 			log.Println("Not a text message")
@@ -125,31 +219,199 @@ func Socket(w http.ResponseWriter, r *http.Request) {
 		log.Println(string(msg))
 		switch req.Type {
 			case "List" : {
-				keys := make([]int, 0)
-				for key,game := range games {
-					// Check if has not been won and has open slots
-					if game.IsOver() || !game.HasOpenSlots() {
-						continue
-					}
-					keys = append(keys, key) 
+				conn.Send(lobbyReply(games.Lobby()))
+			}
+			case "Subscribe": {
+				games.Subscribe(conn)
+			}
+			case "Unsubscribe": {
+				games.Unsubscribe(conn)
+			}
+			case "Spectate": {
+				game := games.Get(req.Game)
+				if game == nil { 
+					log.Println("No such game", req.Game)
+					continue
+				}
+				if player != -1 || watching != nil {
+					sendError(conn, errors.New("Already at a table"))
+					continue
+				}
+				if err := games.CheckCode(req.Game, req.Code); err != nil {
+					sendError(conn, err)
+					continue
+				}
+				// Data asks to see every hand
+				var open bool
+				if req.Data != "" {
+					json.Unmarshal([]byte(req.Data), &open)
+				}
+				game.Lock()
+				if game.IsOver() {
+					game.Unlock()
+					sendError(conn, errors.New("Game is over, watch the replay instead"))
+					continue
+				}
+				games.AddSpectator(game, &Spectator{Name: req.Name, Conn: conn, Open: open})
+				watching = game
+				watchName = req.Name
+				jsn, _ := json.Marshal(game.GetKey())
+				reply := Reply{Type: "Spectate", Data: string(jsn)}
+				repJsn, _ := json.Marshal(reply)
+				conn.Send(repJsn)
+				serverChat(game, req.Name + " is watching")
+				updateSpectators(game)
+				game.Unlock()
+			}
+			case "Exhibition": {
+				if CreateGameFunc == nil {
+					log.Println("Attempted to create game without CreateGameFunc being set")
+					continue
+				}
+				if player != -1 || watching != nil {
+					sendError(conn, errors.New("Already at a table"))
+					continue
+				}
+				var ex Exhibition
+				if err := json.Unmarshal([]byte(req.Data), &ex); err != nil {
+					sendError(conn, err)
+					continue
+				}
+				delay := time.Duration(ex.Delay) * time.Millisecond
+				if delay < 0 || delay > MaxDelay {
+					sendError(conn, fmt.Errorf("Delay must be between 0 and %v", MaxDelay))
+					continue
+				}
+				if len(req.Types) == 0 || slices.Contains(req.Types, "Human") {
+					sendError(conn, ErrBotsOnly)
+					continue
+				}
+				key, err := games.NextKey()
+				if err != nil {
+					sendError(conn, err)
+					continue
+				}
+				game := CreateGameFunc()
+				game.SetKey(key)
+				for _,typ := range req.Types {
+					game.AddPlayer(Player{Type: typ, Joined: true})
+				}
+				exPacer := NewPacer(delay)
+				game.Pace(exPacer)
+				if err := game.Init(""); err != nil {
+					games.Release()
+					log.Println(err)
+					sendError(conn, err)
+					continue
+				}
+				game.Lock()
+				games.Add(game, "")
+				// The host watches with every hand showing
+				games.AddSpectator(game, &Spectator{Name: req.Name, Conn: conn, Open: true})
+				watching = game
+				watchName = req.Name
+				pacer = exPacer
+				jsn, _ := json.Marshal(game.GetKey())
+				reply := Reply{Type: "Spectate", Data: string(jsn)}
+				repJsn, _ := json.Marshal(reply)
+				conn.Send(repJsn)
+				updateSpectators(game)
+				game.Unlock()
+			}
+			case "Pause", "Step", "Resume": {
+				if pacer == nil {
+					log.Println("Not hosting an exhibition")
+					continue
 				}
-				sort.Ints(keys)
+				switch req.Type {
+					case "Pause": pacer.Pause()
+					case "Step": pacer.Step()
+					case "Resume": pacer.Resume()
+				}
+				jsn, _ := json.Marshal(pacer.State())
+				reply := Reply{Type: "Pace", Data: string(jsn)}
+				repJsn, _ := json.Marshal(reply)
+				conn.Send(repJsn)
+			}
+			case "OpenTable": {
+				game := games.Get(req.Game)
+				if game == nil || player == -1 || player >= len(game.GetPlayers()) {
+					log.Println("Only seated players open the table")
+					continue
+				}
+				var open bool
+				json.Unmarshal([]byte(req.Data), &open)
+				game.Lock()
+				game.GetPlayers()[player].OpenTable = open
+				updateSpectators(game)
+				game.Unlock()
+			}
+			case "Finished": {
+				// Games ended in the waiting room have nothing to replay
+				keys := games.Keys(func (game Game) bool {
+					return game.IsOver() && !games.InRoom(game)
+				})
 				jsn, _ := json.Marshal(keys)
-				reply := Reply{Type: "List", Data: string(jsn)}
+				reply := Reply{Type: "Finished", Data: string(jsn)}
 				repJsn, _ := json.Marshal(reply)
-				err = conn.WriteMessage(websocket.TextMessage, repJsn)
+				conn.Send(repJsn)
+			}
+			case "Replay": {
+				game := games.Get(req.Game)
+				if game == nil { 
+					log.Println("No such game", req.Game)
+					continue
+				}
+				var pos ReplayPos
+				var data string
+				err := json.Unmarshal([]byte(req.Data), &pos)
+				if err == nil && !isOver(game) {
+					err = errors.New("Game isn't over yet")
+				}
+				if err == nil {
+					err = games.CheckCode(req.Game, req.Code)
+				}
+				if err == nil {
+					game.Lock()
+					if games.InRoom(game) {
+						err = ErrNotStarted
+					} else {
+						data, err = game.Replay(pos.Hand, pos.Move, pos.Seat)
+					}
+					game.Unlock()
+				}
 				if err != nil {
 					log.Println(err)
+					jsn, _ := json.Marshal(err.Error())
+					reply := Reply{Type: "Error", Data: string(jsn)}
+					repJsn, _ := json.Marshal(reply)
+					conn.Send(repJsn)
 					continue
 				}
+				reply := Reply{Type: "Replay", Data: data}
+				repJsn, _ := json.Marshal(reply)
+				conn.Send(repJsn)
 			}
 			case "New": {
 				if CreateGameFunc == nil {
 					log.Println("Attempted to create game without CreateGameFunc being set")
 					continue
 				}
+				if watching != nil {
+					sendError(conn, ErrSpectator)
+					continue
+				}
+				key, err := games.NextKey()
+				if err != nil {
+					log.Println(err)
+					jsn, _ := json.Marshal(err.Error())
+					reply := Reply{Type: "Error", Data: string(jsn)}
+					repJsn, _ := json.Marshal(reply)
+					conn.Send(repJsn)
+					continue
+				}
 				game := CreateGameFunc()
-				game.SetKey(NextGameIdx())
+				game.SetKey(key)
 				// Add players, and also
 				// Check if we have a human player or send everything to conn 0
 				player = -1
@@ -174,72 +436,170 @@ func Socket(w http.ResponseWriter, r *http.Request) {
 				if player == -1 {
 					game.GetPlayers()[0].Conn = conn
 				}
-				// Check game state okay
-				err := game.Init(req.Data)
+				// Players gather first if there's anyone to wait for
+				room := needsRoom(req.Types)
+				if room {
+					err = game.Configure(req.Data)
+				} else {
+					err = game.Init(req.Data)
+				}
 				if err != nil {
+					games.Release()
 					log.Println("Error in game init")
 					log.Println(err)
 					jsn, _ := json.Marshal(err.Error())
 					reply := Reply{Type: "Error", Data: string(jsn)}
 					repJsn, _ := json.Marshal(reply)
-					conn.WriteMessage(websocket.TextMessage, repJsn);
+					conn.Send(repJsn)
 					continue
 				}
-				// Not protected by mutex because game only becomes visible here
-				games[game.GetKey()] = game
+				// Game only becomes visible here
+				// Bots may already be moving
+				code := req.Code
+				if req.Private && code == "" {
+					code = NewInviteCode()
+				}
+				game.Lock()
+				games.Add(game, code)
+				if room {
+					games.OpenRoom(game, game.GetPlayers()[player], req.Data)
+				}
+				game.Unlock()
 				// Send the game ID to the player
 				jsn, _ := json.Marshal(game.GetKey())
 				reply := Reply{Type: "New", Data: string(jsn)}
 				repJsn, _ := json.Marshal(reply)
-				conn.WriteMessage(websocket.TextMessage, repJsn);
-				// For ending games on closed connections
+				conn.Send(repJsn)
+				if code != "" {
+					jsn, _ := json.Marshal(Invite{Game: game.GetKey(), Code: code})
+					reply := Reply{Type: "Invite", Data: string(jsn)}
+					repJsn, _ := json.Marshal(reply)
+					conn.Send(repJsn)
+				}
+				// For holding seats on closed connections
 				socketGame = game
+				game.Lock()
+				if player != -1 {
+					startSession(game, player)
+				}
 				// Update single player
 				UpdatePlayers(game)
+				game.Unlock()
 			}
 			case "Join": {
-				game := games[req.Game]
+				game := games.Get(req.Game)
 				if game == nil { 
 					log.Println("No such game ", req.Game)
 					continue
 				}
+				if watching != nil {
+					sendError(conn, ErrSpectator)
+					continue
+				}
+				if err := games.CheckCode(req.Game, req.Code); err != nil {
+					sendError(conn, err)
+					continue
+				}
 				game.Lock()
-				player = -1
-				for i,p := range game.GetPlayers() {
-					if !p.Joined {
-						player = i
-						p.Name = req.Name
-						p.Joined = true
-						p.Conn = conn
-						break
+				sav := saveSeating(game)
+				seat, err := joinSeat(game, req.Seat, req.Name, conn)
+				if err == nil {
+					err = game.Join(req.Data)
+					if err != nil {
+						sav.restore(game)
 					}
 				}
-				game.Unlock()
-				if player == -1 {
-					log.Println("No open slots")
+				if err != nil {
+					game.Unlock()
+					log.Println(err)
+					sendError(conn, err)
 					continue
 				}
-				game.Lock()
+				player = seat
 				// Confirm join action by giving player a new gameId
 				// (although he should already know)
-				err := game.Join(req.Data)
-				if err == nil {
-					jsn, _ := json.Marshal(game.GetKey())
-					reply := Reply{Type: "Join", Data: string(jsn)}
-					repJsn, _ := json.Marshal(reply)
-					conn.WriteMessage(websocket.TextMessage, repJsn);
+				jsn, _ := json.Marshal(game.GetKey())
+				reply := Reply{Type: "Join", Data: string(jsn)}
+				repJsn, _ := json.Marshal(reply)
+				conn.Send(repJsn)
+				startSession(game, player)
+				UpdatePlayers(game)
+				// Seated names show in the lobby
+				games.changed()
+				// For holding seats on closed connections
+				socketGame = game
+				game.Unlock()
+			}
+			case "Seat": {
+				if socketGame == nil || player == -1 {
+					log.Println("Not seated")
+					continue
+				}
+				var want int
+				if err := json.Unmarshal([]byte(req.Data), &want); err != nil {
+					sendError(conn, err)
+					continue
+				}
+				socketGame.Lock()
+				err := requestSeat(socketGame, player, want)
+				socketGame.Unlock()
+				if err != nil {
+					sendError(conn, err)
+				}
+			}
+			case "Start": {
+				if socketGame == nil {
+					log.Println("Not at a table")
+					continue
+				}
+				game := socketGame
+				game.Lock()
+				if err := startRoom(game, player); err != nil {
+					log.Println(err)
+					sendError(conn, err)
+				} else {
 					UpdatePlayers(game)
 				}
-				// For ending games on closed connections
+				game.Unlock()
+			}
+			case "Reconnect": {
+				game := games.Get(req.Game)
+				seat := -1
+				if game != nil {
+					seat = reconnect(game, req.Data, conn)
+				}
+				if seat == -1 {
+					log.Println("No session to reclaim in game", req.Game)
+					reply := Reply{Type: "Expired"}
+					repJsn, _ := json.Marshal(reply)
+					conn.Send(repJsn)
+					continue
+				}
+				player = seat
 				socketGame = game
+				jsn, _ := json.Marshal(game.GetKey())
+				reply := Reply{Type: "Join", Data: string(jsn)}
+				repJsn, _ := json.Marshal(reply)
+				conn.Send(repJsn)
+				game.Lock()
+				UpdatePlayers(game)
 				game.Unlock()
 			}
 			case "Action": {
-				game := games[req.Game]
+				game := games.Get(req.Game)
 				if game == nil { 
 					log.Println("No such game", req.Game)
 					continue
 				}
+				if watching != nil {
+					sendError(conn, ErrSpectator)
+					continue
+				}
+				// Seats are only good at their own table
+				if game != socketGame {
+					sendError(conn, ErrNotSeated)
+					continue
+				}
 				if player == -1 || player >= len(game.GetPlayers()) {
 					log.Println("Invalid player")
 					continue
@@ -249,11 +609,16 @@ func Socket(w http.ResponseWriter, r *http.Request) {
 					log.Println("Player not joined or not human")
 					continue
 				}
-				if game.IsOver() {
+				if isOver(game) {
 					log.Println("Game already over")
 					continue
 				}
 				game.Lock()
+				if games.InRoom(game) {
+					game.Unlock()
+					sendError(conn, ErrNotStarted)
+					continue
+				}
 				// Let the game do what it wants with the action
 				err := game.Action(req.Data)
 				if err != nil {
@@ -263,28 +628,70 @@ func Socket(w http.ResponseWriter, r *http.Request) {
 				}
 				game.Unlock()
 			}
+			case "Hint": {
+				game := games.Get(req.Game)
+				if game == nil { 
+					log.Println("No such game", req.Game)
+					continue
+				}
+				if watching != nil {
+					sendError(conn, ErrSpectator)
+					continue
+				}
+				// Seats are only good at their own table
+				if game != socketGame {
+					sendError(conn, ErrNotSeated)
+					continue
+				}
+				if player == -1 || player >= len(game.GetPlayers()) {
+					log.Println("Invalid player")
+					continue
+				}
+				if isOver(game) {
+					log.Println("Game already over")
+					continue
+				}
+				game.Lock()
+				waiting := games.InRoom(game)
+				game.Unlock()
+				if waiting {
+					sendError(conn, ErrNotStarted)
+					continue
+				}
+				hint, err := game.Hint(player)
+				if err != nil {
+					log.Println(err)
+					jsn, _ := json.Marshal(err.Error())
+					reply := Reply{Type: "Error", Data: string(jsn)}
+					repJsn, _ := json.Marshal(reply)
+					conn.Send(repJsn)
+					continue
+				}
+				reply := Reply{Type: "Hint", Data: hint}
+				repJsn, _ := json.Marshal(reply)
+				conn.Send(repJsn)
+			}
 			case "Chat": {
-				game := games[req.Game]
+				game := games.Get(req.Game)
 				if game == nil { 
 					log.Println("No such game", req.Game)
 					continue
 				}
-				if player <= -1 || player >= len(game.GetPlayers()) {
+				var chat Chat
+				if watching == game {
+					chat = Chat{Name: watchName, Message: req.Data, Spectator: true}
+				} else if game == socketGame && player > -1 && player < len(game.GetPlayers()) {
+					chat = Chat{Name: game.GetPlayers()[player].Name, Message: req.Data}
+				} else {
 					log.Println("Chat from bad player")
 					continue
 				}
-				myName := game.GetPlayers()[player].Name
-				chat := Chat{Name: myName, Message: req.Data}
 				jsnChat, _ := json.Marshal(chat)
 				reply := Reply{Type: "Chat", Data: string(jsnChat)}
 				jsnReply, _ := json.Marshal(reply)
-				// Write message to all connected players
+				// Write message to everyone at the table
 				game.Lock()
-				for _,p := range game.GetPlayers() {
-					if p.Conn != nil {
-						p.Conn.WriteMessage(websocket.TextMessage, jsnReply);
-					}
-				}
+				broadcast(game, jsnReply)
 				game.Unlock()
 			}
 		}
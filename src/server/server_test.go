@@ -0,0 +1,747 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Minimal game, every action just counts
+type testGame struct {
+	sync.Mutex
+	Key int
+	Players []*Player
+	Terminated bool
+	Moves int
+	Bots map[int]bool
+}
+
+func (game *testGame) GetKey() int { return game.Key }
+func (game *testGame) SetKey(key int) { game.Key = key }
+func (game *testGame) IsOver() bool { return game.Terminated }
+func (game *testGame) AddPlayer(p Player) { game.Players = append(game.Players, &p) }
+func (game *testGame) GetPlayers() []*Player { return game.Players }
+func (game *testGame) Join(data string) error {
+	if data != "" {
+		return fmt.Errorf("Can't join with %s", data)
+	}
+	return nil
+}
+
+func (game *testGame) Configure(config string) error {
+	if config != "" {
+		return fmt.Errorf("Unknown option %s", config)
+	}
+	return nil
+}
+
+// Bot seats just push updates, racing the request handlers
+func (game *testGame) Init(config string) error {
+	if err := game.Configure(config); err != nil {
+		return err
+	}
+	for _,p := range game.Players {
+		if p.Type != "Bot" {
+			continue
+		}
+		go func () {
+			for i := 0; i < 50 && !isOver(game); i++ {
+				game.Lock()
+				game.Moves++
+				UpdatePlayers(game)
+				game.Unlock()
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	return nil
+}
+func (game *testGame) Terminate() { game.Terminated = true }
+
+func (game *testGame) HasOpenSlots() bool {
+	for _,p := range game.Players {
+		if !p.Joined {
+			return true
+		}
+	}
+	return false
+}
+
+func (game *testGame) Action(string) error {
+	game.Moves++
+	return nil
+}
+
+func (game *testGame) GetState(player int) (string, error) {
+	return fmt.Sprintf(`{"Player": %d, "Moves": %d}`, player, game.Moves), nil
+}
+
+func (game *testGame) Hint(int) (string, error) {
+	return "", errors.New("No hints")
+}
+
+func (game *testGame) Replay(int, int, int) (string, error) {
+	return "", errors.New("No replays")
+}
+
+func (game *testGame) Spectate(open bool) (string, error) {
+	return fmt.Sprintf(`{"Open": %v, "Moves": %d}`, open, game.Moves), nil
+}
+
+func (game *testGame) Pace(*Pacer) {}
+func (game *testGame) GetType() string { return "Test" }
+func (game *testGame) GetOptions() any { return nil }
+
+func (game *testGame) StartBot(seat int, typ string) error {
+	if game.Bots == nil {
+		game.Bots = make(map[int]bool)
+	}
+	game.Bots[seat] = true
+	return nil
+}
+
+func (game *testGame) StopBot(seat int) {
+	delete(game.Bots, seat)
+}
+
+func startTestServer(t *testing.T) string {
+	CreateGameFunc = func () Game { return &testGame{} }
+	srv := httptest.NewServer(http.HandlerFunc(Socket))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func () { conn.Close() })
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, req Request) {
+	if err := conn.WriteJSON(req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Skip other replies until one of type typ
+func readReply(t *testing.T, conn *websocket.Conn, typ string) Reply {
+	conn.SetReadDeadline(time.Now().Add(5*time.Second))
+	for {
+		var reply Reply
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("Waiting for %s: %v", typ, err)
+		}
+		if reply.Type == typ {
+			return reply
+		}
+	}
+}
+
+// Wait until a reply of each type has arrived
+func readReplies(t *testing.T, conn *websocket.Conn, types ...string) {
+	conn.SetReadDeadline(time.Now().Add(5*time.Second))
+	for len(types) > 0 {
+		var reply Reply
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("Waiting for %v: %v", types, err)
+		}
+		types = slices.DeleteFunc(types, func (typ string) bool { return typ == reply.Type })
+	}
+}
+
+// Host deals once everyone is seated
+func startGame(t *testing.T, host *websocket.Conn) {
+	send(t, host, Request{Type: "Start"})
+	readReply(t, host, "Update")
+}
+
+func readSession(t *testing.T, conn *websocket.Conn) Session {
+	var sess Session
+	if err := json.Unmarshal([]byte(readReply(t, conn, "Session").Data), &sess); err != nil {
+		t.Fatal(err)
+	}
+	return sess
+}
+
+func TestReconnectWithToken(t *testing.T) {
+	ReconnectGrace = 500*time.Millisecond
+	url := startTestServer(t)
+	a := dial(t, url)
+	send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Human"}})
+	sessA := readSession(t, a)
+	b := dial(t, url)
+	send(t, b, Request{Type: "Join", Game: sessA.Game, Name: "B"})
+	sessB := readSession(t, b)
+	startGame(t, a)
+	if sessB.Seat != 1 || sessB.Token == sessA.Token {
+		t.Fatalf("Bad session for B %v", sessB)
+	}
+	// A drops and comes back
+	a.Close()
+	readReply(t, b, "Chat")
+	a = dial(t, url)
+	send(t, a, Request{Type: "Reconnect", Game: sessA.Game, Data: "wrong"})
+	readReply(t, a, "Expired")
+	send(t, a, Request{Type: "Reconnect", Game: sessA.Game, Data: sessA.Token})
+	readReply(t, a, "Join")
+	var state struct{ Player int }
+	json.Unmarshal([]byte(readReply(t, a, "Update").Data), &state)
+	if state.Player != 0 {
+		t.Errorf("Reconnected to seat %d", state.Player)
+	}
+	// Seat still works after the grace period
+	time.Sleep(2*ReconnectGrace)
+	game := games.Get(sessA.Game)
+	if isOver(game) {
+		t.Fatal("Game ended despite reconnect")
+	}
+	send(t, a, Request{Type: "Action", Game: sessA.Game})
+	readReply(t, b, "Update")
+}
+
+func TestDisconnectEndsGameAfterGrace(t *testing.T) {
+	ReconnectGrace = 200*time.Millisecond
+	url := startTestServer(t)
+	a := dial(t, url)
+	send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Human"}})
+	sess := readSession(t, a)
+	b := dial(t, url)
+	send(t, b, Request{Type: "Join", Game: sess.Game, Name: "B"})
+	readSession(t, b)
+	startGame(t, a)
+	a.Close()
+	game := games.Get(sess.Game)
+	time.Sleep(ReconnectGrace/2)
+	game.Lock()
+	over := game.IsOver()
+	game.Unlock()
+	if over {
+		t.Fatal("Game ended before the grace period")
+	}
+	time.Sleep(2*ReconnectGrace)
+	game.Lock()
+	over = game.IsOver()
+	game.Unlock()
+	if !over {
+		t.Fatal("Game still going after the grace period")
+	}
+}
+
+func TestBotTakeover(t *testing.T) {
+	ReconnectGrace = 200*time.Millisecond
+	BotTakeover = true
+	defer func () { BotTakeover = false }()
+	url := startTestServer(t)
+	a := dial(t, url)
+	send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Human"}})
+	sess := readSession(t, a)
+	b := dial(t, url)
+	send(t, b, Request{Type: "Join", Game: sess.Game, Name: "B"})
+	readSession(t, b)
+	startGame(t, a)
+	a.Close()
+	readReply(t, b, "Chat")
+	game := games.Get(sess.Game).(*testGame)
+	time.Sleep(2*ReconnectGrace)
+	game.Lock()
+	if game.IsOver() || !game.Bots[0] {
+		t.Errorf("Bot didn't take over, over %v bots %v", game.IsOver(), game.Bots)
+	}
+	game.Unlock()
+	a = dial(t, url)
+	send(t, a, Request{Type: "Reconnect", Game: sess.Game, Data: sess.Token})
+	readReply(t, a, "Join")
+	game.Lock()
+	if game.Bots[0] {
+		t.Error("Bot still playing after reconnect")
+	}
+	game.Unlock()
+}
+
+// Run with -race
+func TestManyClients(t *testing.T) {
+	url := startTestServer(t)
+	nGames := 20
+	var wg sync.WaitGroup
+	keys := make([]int, nGames)
+	for g := 0; g < nGames; g++ {
+		wg.Add(1)
+		go func () {
+			defer wg.Done()
+			a := dial(t, url)
+			send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Bot", "Human"}})
+			sess := readSession(t, a)
+			keys[g] = sess.Game
+			b := dial(t, url)
+			send(t, b, Request{Type: "Join", Game: sess.Game, Name: "B"})
+			readSession(t, b)
+			startGame(t, a)
+			for i := 0; i < 20; i++ {
+				send(t, a, Request{Type: "Action", Game: sess.Game})
+				send(t, b, Request{Type: "Chat", Game: sess.Game, Data: "hi"})
+				send(t, b, Request{Type: "List"})
+				send(t, a, Request{Type: "Finished"})
+			}
+			readReplies(t, a, "Chat", "Finished", "Update")
+			readReplies(t, b, "Chat", "List", "Update")
+		}()
+	}
+	wg.Wait()
+	slices.Sort(keys)
+	if len(slices.Compact(keys)) != nGames {
+		t.Errorf("Games share keys %v", keys)
+	}
+	for _,key := range keys {
+		if games.Get(key) == nil {
+			t.Errorf("Game %d missing from registry", key)
+		}
+	}
+}
+
+func TestReapIdleAndFinished(t *testing.T) {
+	defer func (idle, keep time.Duration) {
+		IdleTimeout, FinishedRetention = idle, keep
+	}(IdleTimeout, FinishedRetention)
+	url := startTestServer(t)
+	a := dial(t, url)
+	send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Human"}})
+	sess := readSession(t, a)
+	game := games.Get(sess.Game).(*testGame)
+	if status, _ := games.Status(sess.Game); status != Waiting {
+		t.Fatalf("New game is %v", status)
+	}
+	b := dial(t, url)
+	send(t, b, Request{Type: "Join", Game: sess.Game, Name: "B"})
+	readSession(t, b)
+	startGame(t, a)
+	game.Lock()
+	game.StartBot(1, "")
+	game.Unlock()
+	if status, _ := games.Status(sess.Game); status != Playing {
+		t.Fatalf("Full game is %v", status)
+	}
+	// Nothing happens while the game is fresh
+	IdleTimeout, FinishedRetention = time.Hour, time.Hour
+	games.Reap()
+	if status, _ := games.Status(sess.Game); status != Playing {
+		t.Fatalf("Fresh game reaped to %v", status)
+	}
+	IdleTimeout = 10*time.Millisecond
+	time.Sleep(2*IdleTimeout)
+	games.Reap()
+	readReply(t, b, "Chat")
+	game.Lock()
+	if !game.IsOver() || len(game.Bots) != 0 {
+		t.Errorf("Idle game not ended, over %v bots %v", game.IsOver(), game.Bots)
+	}
+	game.Unlock()
+	if status, _ := games.Status(sess.Game); status != Abandoned {
+		t.Fatalf("Idle game is %v", status)
+	}
+	// Abandoned games stay for replays, then go
+	games.Reap()
+	if games.Get(sess.Game) == nil {
+		t.Fatal("Abandoned game evicted early")
+	}
+	FinishedRetention = 10*time.Millisecond
+	time.Sleep(2*FinishedRetention)
+	games.Reap()
+	if games.Get(sess.Game) != nil {
+		t.Fatal("Abandoned game not evicted")
+	}
+}
+
+func TestMaxGames(t *testing.T) {
+	defer func (max int) { MaxGames = max }(MaxGames)
+	url := startTestServer(t)
+	a := dial(t, url)
+	games.Lock()
+	MaxGames = games.live + 1
+	games.Unlock()
+	send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Human"}})
+	sess := readSession(t, a)
+	b := dial(t, url)
+	send(t, b, Request{Type: "New", Name: "B", Types: []string{"Human", "Human"}})
+	var msg string
+	json.Unmarshal([]byte(readReply(t, b, "Error").Data), &msg)
+	if msg != ErrTooManyGames.Error() {
+		t.Errorf("Wrong error %q", msg)
+	}
+	// Finishing a game frees its slot
+	game := games.Get(sess.Game)
+	game.Lock()
+	game.Terminate()
+	UpdatePlayers(game)
+	game.Unlock()
+	send(t, b, Request{Type: "New", Name: "B", Types: []string{"Human", "Human"}})
+	readSession(t, b)
+}
+
+func readSpectate(t *testing.T, conn *websocket.Conn) bool {
+	var state struct{ Open bool }
+	json.Unmarshal([]byte(readReply(t, conn, "Update").Data), &state)
+	return state.Open
+}
+
+func TestSpectate(t *testing.T) {
+	url := startTestServer(t)
+	a := dial(t, url)
+	send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Human"}})
+	sess := readSession(t, a)
+	b := dial(t, url)
+	send(t, b, Request{Type: "Join", Game: sess.Game, Name: "B"})
+	readSession(t, b)
+	startGame(t, a)
+	c := dial(t, url)
+	send(t, c, Request{Type: "Spectate", Game: sess.Game, Name: "C", Data: "true"})
+	readReply(t, c, "Spectate")
+	if readSpectate(t, c) {
+		t.Fatal("Hands shown without the table's permission")
+	}
+	// Every seated human has to agree
+	send(t, a, Request{Type: "OpenTable", Game: sess.Game, Data: "true"})
+	if readSpectate(t, c) {
+		t.Fatal("Hands shown with one player's permission")
+	}
+	send(t, b, Request{Type: "OpenTable", Game: sess.Game, Data: "true"})
+	if !readSpectate(t, c) {
+		t.Fatal("Hands hidden with the table's permission")
+	}
+	// Moves reach spectators
+	send(t, a, Request{Type: "Action", Game: sess.Game})
+	readReply(t, c, "Update")
+	// But spectators can't move
+	send(t, c, Request{Type: "Action", Game: sess.Game})
+	var msg string
+	json.Unmarshal([]byte(readReply(t, c, "Error").Data), &msg)
+	if msg != ErrSpectator.Error() {
+		t.Errorf("Wrong error %q", msg)
+	}
+	send(t, c, Request{Type: "Join", Game: sess.Game, Name: "C"})
+	readReply(t, c, "Error")
+	send(t, c, Request{Type: "Chat", Game: sess.Game, Data: "hi"})
+	var chat Chat
+	for chat.Message != "hi" {
+		json.Unmarshal([]byte(readReply(t, a, "Chat").Data), &chat)
+	}
+	if chat.Name != "C" || !chat.Spectator {
+		t.Errorf("Bad spectator chat %v", chat)
+	}
+	game := games.Get(sess.Game).(*testGame)
+	game.Lock()
+	moves := game.Moves
+	game.Unlock()
+	if moves != 1 {
+		t.Errorf("%d moves made", moves)
+	}
+	c.Close()
+	for i := 0; i < 100 && len(games.Spectators(game)) > 0; i++ {
+		time.Sleep(10*time.Millisecond)
+	}
+	if n := len(games.Spectators(game)); n != 0 {
+		t.Errorf("%d spectators left after closing", n)
+	}
+}
+
+func TestPacer(t *testing.T) {
+	pacer := NewPacer(50*time.Millisecond)
+	if !pacer.Take() {
+		t.Fatal("First move held back")
+	}
+	if pacer.Ready() || pacer.Take() {
+		t.Fatal("Move allowed before the delay")
+	}
+	time.Sleep(60*time.Millisecond)
+	if !pacer.Ready() || !pacer.Take() {
+		t.Fatal("Move held back after the delay")
+	}
+	pacer.Pause()
+	time.Sleep(60*time.Millisecond)
+	if pacer.Ready() || pacer.Take() {
+		t.Fatal("Move allowed while paused")
+	}
+	pacer.Step()
+	if !pacer.Take() || pacer.Take() {
+		t.Fatal("Step should allow exactly one move")
+	}
+	pacer.Resume()
+	time.Sleep(60*time.Millisecond)
+	if !pacer.Take() {
+		t.Fatal("Move held back after resuming")
+	}
+}
+
+func TestExhibition(t *testing.T) {
+	url := startTestServer(t)
+	a := dial(t, url)
+	send(t, a, Request{Type: "Exhibition", Name: "A", Types: []string{"Human", "Bot"}, Data: `{"Delay": 100}`})
+	var msg string
+	json.Unmarshal([]byte(readReply(t, a, "Error").Data), &msg)
+	if msg != ErrBotsOnly.Error() {
+		t.Errorf("Wrong error %q", msg)
+	}
+	send(t, a, Request{Type: "Exhibition", Name: "A", Types: []string{"Bot", "Bot"}, Data: `{"Delay": 60000}`})
+	readReply(t, a, "Error")
+	send(t, a, Request{Type: "Exhibition", Name: "A", Types: []string{"Bot", "Bot"}, Data: `{"Delay": 100}`})
+	var key int
+	json.Unmarshal([]byte(readReply(t, a, "Spectate").Data), &key)
+	if !readSpectate(t, a) {
+		t.Error("Exhibition hands hidden")
+	}
+	send(t, a, Request{Type: "Pause"})
+	var pace PaceState
+	json.Unmarshal([]byte(readReply(t, a, "Pace").Data), &pace)
+	if !pace.Paused {
+		t.Error("Not paused")
+	}
+	send(t, a, Request{Type: "Action", Game: key})
+	readReply(t, a, "Error")
+	// The exhibition ends with its host
+	a.Close()
+	game := games.Get(key)
+	for i := 0; i < 100 && !isOver(game); i++ {
+		time.Sleep(10*time.Millisecond)
+	}
+	if !isOver(game) {
+		t.Error("Exhibition still going after the host left")
+	}
+}
+
+// Read pushed lobbies until the game's entry passes check, nil if it's gone
+func waitListing(t *testing.T, conn *websocket.Conn, key int, check func (*Listing) bool) {
+	conn.SetReadDeadline(time.Now().Add(5*time.Second))
+	for {
+		var lobby []Listing
+		if err := json.Unmarshal([]byte(readReply(t, conn, "List").Data), &lobby); err != nil {
+			t.Fatal(err)
+		}
+		var lst *Listing
+		if i := slices.IndexFunc(lobby, func (lst Listing) bool { return lst.Key == key }); i != -1 {
+			lst = &lobby[i]
+		}
+		if check(lst) {
+			return
+		}
+	}
+}
+
+func TestLobbySubscription(t *testing.T) {
+	url := startTestServer(t)
+	lobby := dial(t, url)
+	send(t, lobby, Request{Type: "Subscribe"})
+	readReply(t, lobby, "List")
+	a := dial(t, url)
+	send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Human"}})
+	sess := readSession(t, a)
+	waitListing(t, lobby, sess.Game, func (lst *Listing) bool {
+		return lst != nil && lst.Game == "Test" && lst.Players[0].Name == "A" &&
+			slices.Equal(lst.OpenSeats, []int{1}) && lst.Status == Waiting && !lst.Created.IsZero()
+	})
+	b := dial(t, url)
+	send(t, b, Request{Type: "Join", Game: sess.Game, Name: "B"})
+	readSession(t, b)
+	startGame(t, a)
+	waitListing(t, lobby, sess.Game, func (lst *Listing) bool {
+		return lst != nil && lst.Players[1].Name == "B" && len(lst.OpenSeats) == 0 && lst.Status == Playing
+	})
+	// Over games leave the lobby
+	game := games.Get(sess.Game)
+	game.Lock()
+	game.Terminate()
+	UpdatePlayers(game)
+	game.Unlock()
+	waitListing(t, lobby, sess.Game, func (lst *Listing) bool { return lst == nil })
+}
+
+func TestPrivateGame(t *testing.T) {
+	url := startTestServer(t)
+	a := dial(t, url)
+	send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Human", "Human"}, Private: true})
+	var invite Invite
+	json.Unmarshal([]byte(readReply(t, a, "Invite").Data), &invite)
+	if invite.Code == "" {
+		t.Fatal("No invite code")
+	}
+	// Hidden from the lobby
+	send(t, a, Request{Type: "List"})
+	var lobby []Listing
+	json.Unmarshal([]byte(readReply(t, a, "List").Data), &lobby)
+	if slices.ContainsFunc(lobby, func (lst Listing) bool { return lst.Key == invite.Game }) {
+		t.Error("Private game listed")
+	}
+	b := dial(t, url)
+	for _,code := range []string{"", "wrong"} {
+		send(t, b, Request{Type: "Join", Game: invite.Game, Name: "B", Code: code})
+		var msg string
+		json.Unmarshal([]byte(readReply(t, b, "Error").Data), &msg)
+		if msg != ErrWrongCode.Error() {
+			t.Errorf("Joined with code %q: %q", code, msg)
+		}
+		send(t, b, Request{Type: "Spectate", Game: invite.Game, Name: "B", Code: code})
+		readReply(t, b, "Error")
+	}
+	send(t, b, Request{Type: "Join", Game: invite.Game, Name: "B", Code: invite.Code})
+	if sess := readSession(t, b); sess.Seat != 1 {
+		t.Errorf("Joined seat %d", sess.Seat)
+	}
+	// A chosen password works the same way
+	c := dial(t, url)
+	send(t, c, Request{Type: "New", Name: "C", Types: []string{"Human", "Human"}, Code: "hunter2"})
+	json.Unmarshal([]byte(readReply(t, c, "Invite").Data), &invite)
+	if invite.Code != "hunter2" {
+		t.Errorf("Password became %q", invite.Code)
+	}
+	d := dial(t, url)
+	send(t, d, Request{Type: "Join", Game: invite.Game, Name: "D", Code: "hunter2"})
+	readSession(t, d)
+}
+
+func readRoom(t *testing.T, conn *websocket.Conn) Room {
+	var room Room
+	json.Unmarshal([]byte(readReply(t, conn, "Room").Data), &room)
+	return room
+}
+
+func readError(t *testing.T, conn *websocket.Conn) string {
+	var msg string
+	json.Unmarshal([]byte(readReply(t, conn, "Error").Data), &msg)
+	return msg
+}
+
+func TestWaitingRoom(t *testing.T) {
+	url := startTestServer(t)
+	a := dial(t, url)
+	send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Bot", "Human", "Human"}})
+	sess := readSession(t, a)
+	if room := readRoom(t, a); room.Host != 0 || room.You != 0 {
+		t.Fatalf("Bad room %v", room)
+	}
+	// Taking a bot's seat moves the bot
+	seat := 1
+	b := dial(t, url)
+	send(t, b, Request{Type: "Join", Game: sess.Game, Name: "B", Seat: &seat})
+	if sess := readSession(t, b); sess.Seat != 1 {
+		t.Fatalf("B sat at %d", sess.Seat)
+	}
+	if room := readRoom(t, b); room.Seats[2].Type != "Bot" || room.Seats[1].Name != "B" {
+		t.Fatalf("Bot didn't move %v", room.Seats)
+	}
+	send(t, b, Request{Type: "Start"})
+	if msg := readError(t, b); msg != ErrNotHost.Error() {
+		t.Errorf("Non-host start: %q", msg)
+	}
+	send(t, a, Request{Type: "Start"})
+	readError(t, a)
+	c := dial(t, url)
+	seat = 0
+	send(t, c, Request{Type: "Join", Game: sess.Game, Name: "C", Seat: &seat})
+	readError(t, c)
+	send(t, c, Request{Type: "Join", Game: sess.Game, Name: "C"})
+	if sess := readSession(t, c); sess.Seat != 3 {
+		t.Fatalf("C sat at %d", sess.Seat)
+	}
+	// Swapping with a human takes both asking
+	send(t, a, Request{Type: "Seat", Data: "3"})
+	readReply(t, c, "Chat")
+	send(t, c, Request{Type: "Seat", Data: "0"})
+	if sess := readSession(t, a); sess.Seat != 3 {
+		t.Fatalf("A swapped to %d", sess.Seat)
+	}
+	if room := readRoom(t, c); room.You != 0 || room.Host != 3 {
+		t.Fatalf("Bad room after swap %v", room)
+	}
+	// The host leaving hands the room on
+	a.Close()
+	for room := readRoom(t, b); room.Host != 0; room = readRoom(t, b) {
+	}
+	d := dial(t, url)
+	send(t, d, Request{Type: "Join", Game: sess.Game, Name: "D"})
+	readSession(t, d)
+	startGame(t, c)
+	readReply(t, b, "Update")
+	send(t, b, Request{Type: "Seat", Data: "0"})
+	if msg := readError(t, b); msg != ErrStarted.Error() {
+		t.Errorf("Seat change after start: %q", msg)
+	}
+	if status, _ := games.Status(sess.Game); status != Playing {
+		t.Errorf("Started game is %v", status)
+	}
+}
+
+// A join the game turns down leaves the seats as they were
+func TestFailedJoin(t *testing.T) {
+	url := startTestServer(t)
+	a := dial(t, url)
+	send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Bot", "Human"}})
+	sess := readSession(t, a)
+	readRoom(t, a)
+	seat := 1
+	b := dial(t, url)
+	send(t, b, Request{Type: "Join", Game: sess.Game, Name: "B", Seat: &seat, Data: "Jokers"})
+	if msg := readError(t, b); msg != "Can't join with Jokers" {
+		t.Errorf("Join error %q", msg)
+	}
+	game := games.Get(sess.Game)
+	game.Lock()
+	players := game.GetPlayers()
+	if players[1].Type != "Bot" || players[2].Type != "Human" || players[2].Joined || players[2].Conn != nil {
+		t.Errorf("Seats after a failed join %+v %+v", players[1], players[2])
+	}
+	game.Unlock()
+	send(t, b, Request{Type: "Join", Game: sess.Game, Name: "B", Seat: &seat})
+	if sess := readSession(t, b); sess.Seat != 1 {
+		t.Fatalf("B sat at %d", sess.Seat)
+	}
+}
+
+// Bad options are refused before anyone waits for the game
+func TestBadConfig(t *testing.T) {
+	url := startTestServer(t)
+	n := games.Len()
+	for _,types := range [][]string{{"Human", "Bot"}, {"Human", "Human"}} {
+		a := dial(t, url)
+		send(t, a, Request{Type: "New", Name: "A", Types: types, Data: "Jokers"})
+		if msg := readError(t, a); msg != "Unknown option Jokers" {
+			t.Errorf("Config error %q", msg)
+		}
+	}
+	if games.Len() != n {
+		t.Errorf("Game kept after bad config")
+	}
+}
+
+// A seat in one game is no use in another
+func TestSeatScoped(t *testing.T) {
+	url := startTestServer(t)
+	a := dial(t, url)
+	send(t, a, Request{Type: "New", Name: "A", Types: []string{"Human", "Bot"}})
+	readSession(t, a)
+	b := dial(t, url)
+	send(t, b, Request{Type: "New", Name: "B", Types: []string{"Human", "Bot"}})
+	other := readSession(t, b).Game
+	for _,typ := range []string{"Hint", "Action"} {
+		send(t, a, Request{Type: typ, Game: other, Data: "{}"})
+		if msg := readError(t, a); msg != ErrNotSeated.Error() {
+			t.Errorf("%s in another game: %q", typ, msg)
+		}
+	}
+	send(t, a, Request{Type: "Chat", Game: other, Data: "psst"})
+	send(t, b, Request{Type: "Chat", Game: other, Data: "hello"})
+	var chat Chat
+	json.Unmarshal([]byte(readReply(t, b, "Chat").Data), &chat)
+	if chat.Name != "B" {
+		t.Errorf("Chat from another game got through: %v", chat)
+	}
+}
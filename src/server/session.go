@@ -0,0 +1,123 @@
+package server
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// How long a disconnected human keeps their seat before the game ends
+var ReconnectGrace = 60 * time.Second
+
+// A bot plays for disconnected humans instead, until they return
+var BotTakeover = false
+
+// Sent to a human on New and Join, presented again to reclaim the seat
+type Session struct {
+	Game int
+	Seat int
+	Token string
+}
+
+func NewToken() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Give the seat a fresh token and send it to the seat's connection
+func startSession(game Game, seat int) {
+	p := game.GetPlayers()[seat]
+	p.Token = NewToken()
+	p.Left = time.Time{}
+	jsn, _ := json.Marshal(Session{Game: game.GetKey(), Seat: seat, Token: p.Token})
+	reply := Reply{Type: "Session", Data: string(jsn)}
+	repJsn, _ := json.Marshal(reply)
+	p.Conn.Send(repJsn)
+}
+
+// Seat holding the token, -1 if none
+func findSession(game Game, token string) int {
+	if token == "" {
+		return -1
+	}
+	for i,p := range game.GetPlayers() {
+		if p.Token == token {
+			return i
+		}
+	}
+	return -1
+}
+
+// Message everyone at the table from the server
+// Call with the game locked
+func serverChat(game Game, msg string) {
+	jsnChat, _ := json.Marshal(Chat{Name: "Server", Message: msg})
+	reply := Reply{Type: "Chat", Data: string(jsnChat)}
+	jsnReply, _ := json.Marshal(reply)
+	broadcast(game, jsnReply)
+}
+
+// Hold a dropped human's seat for the grace period
+// The game ends if they haven't reconnected by then,
+// unless a bot has taken over the seat
+func disconnect(game Game, seat int, conn *Conn) {
+	game.Lock()
+	defer game.Unlock()
+	p := game.GetPlayers()[seat]
+	// Already reconnected on another connection
+	if p.Conn != conn {
+		return
+	}
+	p.Conn = nil
+	if game.IsOver() {
+		return
+	}
+	// Nothing to hold before the deal
+	if games.InRoom(game) {
+		leaveRoom(game, seat)
+		return
+	}
+	p.Left = time.Now()
+	if BotTakeover {
+		err := game.StartBot(seat, "")
+		if err == nil {
+			serverChat(game, fmt.Sprintf("%s disconnected, a bot plays for them until they return", p.Name))
+			return
+		}
+		log.Println(err)
+	}
+	serverChat(game, fmt.Sprintf("%s disconnected, holding their seat for %v", p.Name, ReconnectGrace))
+	time.AfterFunc(ReconnectGrace, func () {
+		game.Lock()
+		defer game.Unlock()
+		if p.Left.IsZero() || time.Since(p.Left) < ReconnectGrace || game.IsOver() {
+			return
+		}
+		endGame(game, fmt.Sprintf("%s didn't return, the game is over", p.Name))
+	})
+}
+
+// Put conn back in the seat the token belongs to
+// Returns the seat or -1
+func reconnect(game Game, token string, conn *Conn) int {
+	game.Lock()
+	defer game.Unlock()
+	seat := findSession(game, token)
+	if seat == -1 || game.IsOver() {
+		return -1
+	}
+	p := game.GetPlayers()[seat]
+	// Drop a stale connection still holding the seat
+	if p.Conn != nil && p.Conn != conn {
+		p.Conn.Close()
+	}
+	p.Conn = conn
+	p.Left = time.Time{}
+	game.StopBot(seat)
+	serverChat(game, fmt.Sprintf("%s reconnected", p.Name))
+	return seat
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+)
+
+// Watches a live game without a seat
+type Spectator struct {
+	Name string
+	Conn *Conn
+	// Asked to see every hand, only honored if the table allows it
+	Open bool
+}
+
+var ErrSpectator = errors.New("Spectators can't play")
+var ErrNotSeated = errors.New("Not seated at that game")
+
+// Call with the game locked
+func (reg *Registry) AddSpectator(game Game, sp *Spectator) {
+	reg.Lock()
+	defer reg.Unlock()
+	if ent := reg.games[game.GetKey()]; ent != nil {
+		ent.spectators = append(ent.spectators, sp)
+		reg.changed()
+	}
+}
+
+// Call with the game locked
+func (reg *Registry) RemoveSpectator(game Game, conn *Conn) {
+	reg.Lock()
+	defer reg.Unlock()
+	if ent := reg.games[game.GetKey()]; ent != nil {
+		ent.spectators = slices.DeleteFunc(ent.spectators, func (sp *Spectator) bool {
+			return sp.Conn == conn
+		})
+		reg.changed()
+	}
+}
+
+// Copy, safe to range over after the registry is unlocked
+func (reg *Registry) Spectators(game Game) []*Spectator {
+	reg.Lock()
+	defer reg.Unlock()
+	if ent := reg.games[game.GetKey()]; ent != nil {
+		return slices.Clone(ent.spectators)
+	}
+	return nil
+}
+
+// Spectators see hands only if every seated human allows it
+// Call with the game locked
+func openTable(game Game) bool {
+	for _,p := range game.GetPlayers() {
+		if p.Type == "Human" && p.Joined && !p.OpenTable {
+			return false
+		}
+	}
+	return true
+}
+
+// Send the table as spectators see it
+// Call with the game locked
+func updateSpectators(game Game) {
+	// Nothing dealt yet, they see the waiting room
+	if games.InRoom(game) {
+		updateRoom(game)
+		return
+	}
+	views := make(map[bool][]byte)
+	open := openTable(game)
+	for _,sp := range games.Spectators(game) {
+		see := sp.Open && open
+		if views[see] == nil {
+			state, err := game.Spectate(see)
+			if err != nil {
+				log.Println("Error in Spectate")
+				continue
+			}
+			reply := Reply{Type: "Update", Data: state}
+			views[see], _ = json.Marshal(reply)
+		}
+		sp.Conn.Send(views[see])
+	}
+}
+
+// Message players and spectators alike
+// Call with the game locked
+func broadcast(game Game, msg []byte) {
+	for _,p := range game.GetPlayers() {
+		if p.Conn != nil {
+			p.Conn.Send(msg)
+		}
+	}
+	for _,sp := range games.Spectators(game) {
+		sp.Conn.Send(msg)
+	}
+}
@@ -6,7 +6,107 @@ import (
 	"time"
 )
 
+// How the play heuristics treat the remaining contract
+type Contract int
+
+const (
+	// Partnership still needs tricks to make its bid
+	MakeContract Contract = iota
+	// Contract made, but opponents are close to going under
+	SetContract
+	// Contract made and opponents safe or already set, extra tricks are only bags
+	// Also a nil bidder's mode, every trick it takes hurts
+	AvoidBags
+)
+
+// Opponents who can afford to lose fewer tricks than this are worth setting
+var SetSlack = 2
+
+// Tricks remaining including the current one
+func (state *GameState) TricksLeft() int {
+	n := 0
+	for _,h := range state.Hands {
+		if len(h) > n {
+			n = len(h)
+		}
+	}
+	return n
+}
+
+// A nil bid plays for no tricks, the partner makes the side's bid alone
+func (state *GameState) IsNil(player int) bool {
+	return state.Bids[player] == 0 && !state.ZeroBids
+}
+
+// Tricks the player's partnership still needs to make its bid
+// A nil bidder's tricks don't count toward it
+func (state *GameState) TricksNeeded(player int) int {
+	n := 0
+	for _,p := range []int{player, (player+2)%4} {
+		if !state.IsNil(p) {
+			n += state.Bids[p] - state.Tricks[p]
+		}
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func (state *GameState) ContractMode(player int) Contract {
+	// Still bidding
+	for _,b := range state.Bids {
+		if b == -1 {
+			return MakeContract
+		}
+	}
+	if state.IsNil(player) {
+		return AvoidBags
+	}
+	if state.TricksNeeded(player) > 0 {
+		return MakeContract
+	}
+	left := state.TricksLeft()
+	oppNeed := state.TricksNeeded((player+1)%4)
+	// Nothing to gain once they can't make it
+	if oppNeed > 0 && oppNeed <= left && left-oppNeed < SetSlack {
+		return SetContract
+	}
+	return AvoidBags
+}
+
+// Win rate a card needs in simulation before we try to win the trick with it
+func (state *GameState) WinThreshold(player int) float64 {
+	switch state.ContractMode(player) {
+		case SetContract: 
+			return 0.3
+		case AvoidBags:
+			// Never try to win
+			return 2.0
+	}
+	// Need every remaining trick
+	if state.TricksNeeded(player) >= state.TricksLeft() {
+		return 0.2
+	}
+	return 0.5
+}
+
+// Whether card would win the trick as it stands
+func (state *GameState) WinsTrick(c Card) bool {
+	for i := 0; i < 4; i++ {
+		if !c.Beats(state.Trick[i], state.Trick[0].Suit()) {
+			return false
+		}
+	}
+	return true
+}
+
 func (state *GameState) DecidePlayFirstFast() Action {
+	// Made our contract, lead low
+	if state.ContractMode(state.Attacker) == AvoidBags {
+		c := state.ChooseLowestCard(state.Attacker)
+		return Action{Verb: PlayVerb, Player: state.Attacker, Card: c}
+	}
 	// Check if you have a high card where all others have been played
 	for _,c := range state.Hands[state.Attacker] {
 		s := c.Suit()
@@ -29,28 +129,72 @@ func (state *GameState) DecidePlayFirstFast() Action {
 	return Action{Verb: PlayVerb, Player: state.Attacker, Card: c}
 }
 
-func (state *GameState) DecideBids(player int, timeBudget int64) int {
+// Distribution of tricks over sampled deals
+type BidEstimate struct {
+	Bid int
+	// Probability of taking each number of tricks
+	Dist [14]float64
+	Mean float64
+	// Probability of taking at least Bid tricks
+	Confidence float64
+	Sims int
+}
+
+// Highest bid made with at least probability p
+func (est BidEstimate) BidWithConfidence(p float64) int {
+	atLeast := 0.0
+	for b := 13; b > 0; b-- {
+		atLeast += est.Dist[b]
+		if atLeast >= p {
+			return b
+		}
+	}
+	return 0
+}
+
+// Probability of taking at least b tricks
+func (est BidEstimate) MakeProbability(b int) float64 {
+	p := 0.0
+	for t := b; t <= 13; t++ {
+		p += est.Dist[t]
+	}
+	return p
+}
+
+func (state *GameState) DecideBids(player int, timeBudget int64) BidEstimate {
 	start := time.Now()
+	counts := [14]int{}
 	sims := 0
-	tricks := 0
 	for time.Since(start).Milliseconds() < timeBudget {
-		t := state.SimulateGame(player)
-		tricks += t
+		t, ok := state.SimulateGame(player)
+		if !ok {
+			continue
+		}
+		counts[t]++
 		sims++
 	}
-	return tricks/sims
+	est := BidEstimate{Sims: sims}
+	if sims == 0 {
+		return est
+	}
+	for t,n := range counts {
+		est.Dist[t] = float64(n) / float64(sims)
+		est.Mean += float64(t) * est.Dist[t]
+	}
+	est.Bid = est.BidWithConfidence(0.5)
+	est.Confidence = est.MakeProbability(est.Bid)
+	return est
 }
 
 // Simulate game to see how many tricks won by player
-func (state *GameState) SimulateGame(player int) int {
+// Other hands are dealt from the cards player hasn't seen
+func (state *GameState) SimulateGame(player int) (int, bool) {
 	st := state.Clone()
-	for i := 0; i < 4; i++ {
-		if (i == player) {
-			continue
-		}
-		st.Hands[i] = st.SimulateHand(player, i) 
+	if !st.SampleHands(player) {
+		return 0, false
 	}
-	st.Bids = [4]int{0,0,0,0}
+	// Nobody makes their bid so everyone plays for tricks
+	st.Bids = [4]int{13,13,13,13}
 	for !st.IsOver() {
 		attacker := st.Attacker
 		act := st.DecidePlayFirstFast()
@@ -60,61 +204,43 @@ func (state *GameState) SimulateGame(player int) int {
 			st.TryWinTrick(p)
 		}
 	}
-	return st.Tricks[player]
+	return st.Tricks[player], true
 }
 
+// One player's hand out of a deal sampled from simulator's point of view
+// Returns nil only if no deal fits what simulator knows
 func (state *GameState) SimulateHand(simulator int, simulated int) []Card {
-	hsize := len(state.Hands[simulated])
-	possible := make([]Card, 0)
-	for i := 0; i < 52; i++ {
-		if !state.Absent[simulator][simulated][i] {
-			possible = append(possible, Card(i))
-		}
-	}
-	if len(possible) < hsize {
+	st := state.Clone()
+	if !st.SampleHands(simulator) {
 		return nil
 	}
-	// Dirty hack
-	// Only seems to happen at the very end of the game
-	/*if len(possible) < hsize {
-		for i := 0; i < 4; i++ {
-			for j := 0; j < 4; j++ {
-				for c := 0; c < 52; c++ {
-					if !state.Absent[i][j][c] {
-						possible = append(possible, Card(c))
-					}
-				}
-			}
-		}
-	}
-	// Dirtier hack so no random crashes in server
-	if len(possible) < hsize {
-		possible = state.Hands[simulated]
-	}*/
-	idcs := rand.Perm(len(possible))
-	hand := make([]Card, hsize)
-	for i := 0; i < hsize; i++ {
-		hand[i] = possible[idcs[i]]
-	}
-	return hand
+	return st.Hands[simulated]
 }
 
 // Assume first card in trick has been played
 // Player hand has been populated with simulated cards
 func (state *GameState) TryWinTrick(player int) {
 	acts := state.PlayerActions(player) 
+	// Duck if we've made our contract
+	if state.ContractMode(player) == AvoidBags {
+		losing := make([]Action, 0)
+		for _,a := range acts {
+			if !state.WinsTrick(a.Card) {
+				losing = append(losing, a)
+			}
+		}
+		if len(losing) > 0 {
+			state.TakeAction(losing[rand.IntN(len(losing))])
+			return
+		}
+	}
 	won := false
 	var act Action
-	outer:
 	for _,a := range acts {
-		c := a.Card
-		for i := 0; i < 4; i++ {
-			if !c.Beats(state.Trick[i], state.Trick[0].Suit()) {
-				continue outer
-			}
+		if state.WinsTrick(a.Card) {
+			won = true
+			act = a
 		}
-		won = true
-		act = a
 	}
 	// Random card for best simulation
 	// Can also get low value card, but may be too expensive for loop
@@ -126,11 +252,18 @@ func (state *GameState) TryWinTrick(player int) {
 
 // Simulate playing each card in hand
 func (state *GameState) DecidePlayFirst(timeBudget int64) Action {
+	act, _ := state.decidePlayFirst(timeBudget, 100000)
+	return act
+}
+
+// At most maxSims sampled deals per card
+// Also returns the chosen card's win rate, or -1 if not trying to win
+func (state *GameState) decidePlayFirst(timeBudget int64, maxSims int) (Action, float64) {
 	hand := state.Hands[state.Attacker]
 	wins := make([]int, len(hand))
 	sims := 0
 	start := time.Now()
-	for i := 0; i < 100000; i++ {
+	for i := 0; i < maxSims*len(hand); i++ {
 		if time.Since(start).Milliseconds() > timeBudget {
 			break
 		}
@@ -139,39 +272,36 @@ func (state *GameState) DecidePlayFirst(timeBudget int64) Action {
 			sims++
 		}
 		st := state.Clone()
+		// Shouldn't happen, fall back to the heuristic below
+		if !st.SampleHands(state.Attacker) {
+			break
+		}
 		c := hand[j]
 		act := Action{Verb: PlayVerb, Card: c, Player: state.Attacker}
 		st.TakeAction(act)
-		outer:
-		for tries := 0; tries < 10; tries++ {
-			for k := 1; k < 4; k++ {
-				pp := (state.Attacker+k)%4
-				// Sometimes simulating a hand leads to bad results at the end
-				// I think that we may do something like taking a player's final
-				// possible card
-				st.Hands[pp] = st.SimulateHand(state.Attacker, pp)
-				if st.Hands[pp] == nil {
-					st = state.Clone()
-					st.TakeAction(act)
-					continue outer
-				}
-				st.TryWinTrick(pp)
-			}
-			break
+		for k := 1; k < 4; k++ {
+			st.TryWinTrick((state.Attacker+k)%4)
 		}
 		if state.Tricks[state.Attacker] != st.Tricks[state.Attacker] {
 			wins[j]++
 		}
 	}
-	c := ChooseWinningCard(hand, wins, sims)
+	c := ChooseWinningCard(hand, wins, sims, state.WinThreshold(state.Attacker))
+	rate := WinRate(hand, wins, sims, c)
 	// Decide none of the win percentages are good enough
 	if c == NO_CARD {
 		c = state.ChooseLowValueCard(state.Attacker)
 	}
-	return Action{Verb: PlayVerb, Player: state.Attacker, Card: c}
+	return Action{Verb: PlayVerb, Player: state.Attacker, Card: c}, rate
 }
 
 func (state *GameState) DecidePlayNotFirst(timeBudget int64) Action {
+	act, _ := state.decidePlayNotFirst(timeBudget, 100000)
+	return act
+}
+
+// Player whose turn it is to play a card
+func (state *GameState) ToPlay() int {
 	player := state.Attacker
 	for i := 0; i < 4; i++ {
 		if state.Trick[i] == NO_CARD {
@@ -179,35 +309,55 @@ func (state *GameState) DecidePlayNotFirst(timeBudget int64) Action {
 			break
 		}
 	}
+	return player
+}
+
+// Win the trick cheaply if we can and want to, else throw a low card
+func (state *GameState) DecidePlayNotFirstFast() Action {
+	player := state.ToPlay()
+	possible := make([]Card, 0)
+	for _,a := range state.PlayerActions(player) {
+		if state.WinsTrick(a.Card) {
+			possible = append(possible, a.Card)
+		}
+	}
+	c := NO_CARD
+	if len(possible) > 0 && state.ContractMode(player) != AvoidBags {
+		c = state.ChooseLowValueWinningCard(possible)
+	} else {
+		c = state.ChooseLowValueCard(player)
+	}
+	return Action{Verb: PlayVerb, Player: player, Card: c}
+}
+
+// At most maxSims sampled deals per card
+// Also returns the chosen card's win rate, or -1 if not trying to win
+func (state *GameState) decidePlayNotFirst(timeBudget int64, maxSims int) (Action, float64) {
+	player := state.ToPlay()
 	// Find cards that win the trick so far
 	// Out of possible in player actions
 	possible := make([]Card, 0)
-	outer:
 	for _,a := range state.PlayerActions(player) {
-		c := a.Card
-		for i := 0; i < 4; i++ {
-			if !c.Beats(state.Trick[i], state.Trick[0].Suit()) {
-				continue outer
-			}
+		if state.WinsTrick(a.Card) {
+			possible = append(possible, a.Card)
 		}
-		possible = append(possible, c)
 	}
-	// Not possible to win the trick
+	// Not possible to win the trick or we don't want to
 	// Choose card to get rid of
-	if len(possible) == 0 {
+	if len(possible) == 0 || state.ContractMode(player) == AvoidBags {
 		c := state.ChooseLowValueCard(player)
-		return Action{Verb: PlayVerb, Player: player, Card: c}
+		return Action{Verb: PlayVerb, Player: player, Card: c}, -1
 	}
 	// We're the last to play and can just win the trick
 	if (player + 1)%4 == state.Attacker {
 		c := state.ChooseLowValueWinningCard(possible)
-		return Action{Verb: PlayVerb, Player: player, Card: c}
+		return Action{Verb: PlayVerb, Player: player, Card: c}, 1
 	}
 	// For each winning card, simulate the probability of it winning the trick
 	wins := make([]int, len(possible))
 	sims := 0
 	start := time.Now()
-	for i := 0; i < 100000; i++ {
+	for i := 0; i < maxSims*len(possible); i++ {
 		if time.Since(start).Milliseconds() > timeBudget {
 			break
 		}
@@ -216,6 +366,10 @@ func (state *GameState) DecidePlayNotFirst(timeBudget int64) Action {
 			sims++
 		}
 		st := state.Clone()
+		// Shouldn't happen, fall back to the heuristic below
+		if !st.SampleHands(player) {
+			break
+		}
 		c := possible[j]
 		act := Action{Verb: PlayVerb, Card: c, Player: player}
 		st.TakeAction(act)
@@ -226,30 +380,39 @@ func (state *GameState) DecidePlayNotFirst(timeBudget int64) Action {
 			}
 		}
 		for k := 0; k < nPlayersSim; k++ {
-			pp := (player+k+1)%4
-			st.Hands[pp] = st.SimulateHand(player, pp)
-			st.TryWinTrick(pp)
+			st.TryWinTrick((player+k+1)%4)
 		}
 		if state.Tricks[player] != st.Tricks[player] {
 			wins[j]++
 		}
 	}
-	c := ChooseWinningCard(possible, wins, sims)
+	c := ChooseWinningCard(possible, wins, sims, state.WinThreshold(player))
+	rate := WinRate(possible, wins, sims, c)
 	// Decide none of the win percentages are good enough
 	if c == NO_CARD {
 		c = state.ChooseLowValueCard(player)
 	}
-	return Action{Verb: PlayVerb, Player: player, Card: c}
+	return Action{Verb: PlayVerb, Player: player, Card: c}, rate
 }
 
-// Simple: choose lowest value winning card over 50% win rate
-// Even simpler: choose lowest win rate over 50%
-func ChooseWinningCard(cards []Card, wins []int, sims int) Card {
+// Fraction of simulations card won, -1 if it wasn't simulated
+func WinRate(cards []Card, wins []int, sims int, card Card) float64 {
+	for i,c := range cards {
+		if c == card && sims > 0 {
+			return float64(wins[i]) / float64(sims)
+		}
+	}
+	return -1
+}
+
+// Simple: choose lowest value winning card over threshold win rate
+// Even simpler: choose lowest win rate over threshold
+func ChooseWinningCard(cards []Card, wins []int, sims int, threshold float64) Card {
 	wmin := -1.0
 	c := NO_CARD
 	for i,w := range wins {
 		fct := float64(w) / float64(sims) 
-		if fct < 0.5 {
+		if fct < threshold {
 			continue
 		}
 		if c == NO_CARD || fct < wmin {
@@ -260,7 +423,40 @@ func ChooseWinningCard(cards []Card, wins []int, sims int) Card {
 	return c
 }
 
+// Lowest rank playable card, spades last
+func (state *GameState) ChooseLowestCard(player int) Card {
+	card := NO_CARD
+	for _,a := range state.PlayerActions(player) {
+		c := a.Card
+		cSpade, cardSpade := c.Suit() == SUIT_SPADES, card.Suit() == SUIT_SPADES
+		if card == NO_CARD || (cardSpade && !cSpade) || (cSpade == cardSpade && c.Rank() < card.Rank()) {
+			card = c
+		}
+	}
+	return card
+}
+
 func (state *GameState) ChooseLowValueCard(player int) Card {
+	// Made our contract and leading: lead low
+	if state.ContractMode(player) == AvoidBags && state.Trick[0] == NO_CARD {
+		return state.ChooseLowestCard(player)
+	}
+	// Made our contract: throw our highest card that loses the trick
+	if state.ContractMode(player) == AvoidBags {
+		card := NO_CARD
+		for _,a := range state.PlayerActions(player) {
+			c := a.Card
+			if state.WinsTrick(c) {
+				continue
+			}
+			if card == NO_CARD || c.Rank() > card.Rank() {
+				card = c
+			}
+		}
+		if card != NO_CARD {
+			return card
+		}
+	}
 	card := NO_CARD
 	val := -1.0
 	hand := make([]Card, len(state.Hands[player])-1)
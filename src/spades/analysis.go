@@ -0,0 +1,159 @@
+package spades
+
+import (
+	"fmt"
+	"time"
+)
+
+// One decision in a finished game, re-searched
+// Scores are expected contract points as in Eval
+type Annotation struct {
+	Move int
+	Action Action
+	Score float64
+	Best Action
+	BestScore float64
+	// Played move scores more than the threshold below the best
+	Blunder bool
+}
+
+func (a Action) Describe() string {
+	if a.Verb == BidVerb {
+		return fmt.Sprintf("Bid %d", a.Bid)
+	}
+	return a.Card.ToStr()
+}
+
+func (a Annotation) ToStr() string {
+	if a.Action == a.Best {
+		return fmt.Sprintf("%d. P%d %s %.1f", a.Move, a.Action.Player, a.Action.Describe(), a.Score)
+	}
+	mark := ""
+	if a.Blunder {
+		mark = " ??"
+	}
+	return fmt.Sprintf("%d. P%d %s %.1f%s (best %s %.1f)", a.Move, a.Action.Player, a.Action.Describe(), a.Score, mark, a.Best.Describe(), a.BestScore)
+}
+
+// Contract points for player with the given final tricks for each side
+func (state *GameState) evalWithTricks(player int, side int, opp int) float64 {
+	st := &GameState{Bids: state.Bids}
+	st.Tricks[player] = side
+	st.Tricks[(player+1)%4] = opp
+	return st.Eval(player)
+}
+
+// Expected points for bidding b given a trick distribution
+// Partner's bid is left out
+func (est BidEstimate) BidValue(b int) float64 {
+	v := 0.0
+	for t,p := range est.Dist {
+		if t >= b {
+			v += p * (10*float64(b) + float64(t-b))
+		} else {
+			v -= p * 10*float64(b)
+		}
+	}
+	return v
+}
+
+// Average points for each card over sampled deals
+// Double dummy near the end, heuristic rollouts before that
+func (state *GameState) ScoreCards(player int, timeBudget int64) ([]Card, []float64) {
+	cards := make([]Card, 0)
+	for _,a := range state.PlayerActions(player) {
+		cards = append(cards, a.Card)
+	}
+	totals := make([]float64, len(cards))
+	sims := 0
+	start := time.Now()
+	partner := (player+2)%4
+	side := state.Tricks[player] + state.Tricks[partner]
+	opp := state.Tricks[(player+1)%4] + state.Tricks[(player+3)%4]
+	for sims == 0 || time.Since(start).Milliseconds() < timeBudget {
+		st := state.Clone()
+		if !st.SampleHands(player) {
+			break
+		}
+		if st.TricksLeft() <= 6 {
+			cs, tricks := st.DoubleDummyCards(player)
+			for i := range cs {
+				more := tricks[i]
+				totals[i] += state.evalWithTricks(player, side + more, opp + st.TricksLeft() - more)
+			}
+		} else {
+			for i,c := range cards {
+				sim := st.Clone()
+				sim.TakeAction(Action{Verb: PlayVerb, Player: player, Card: c})
+				sim.Rollout()
+				totals[i] += sim.Eval(player)
+			}
+		}
+		sims++
+	}
+	if sims == 0 {
+		return cards, totals
+	}
+	for i := range totals {
+		totals[i] /= float64(sims)
+	}
+	return cards, totals
+}
+
+// Finish the hand with the fast heuristics
+func (state *GameState) Rollout() {
+	for !state.IsOver() {
+		p := state.ToPlay()
+		if state.Trick[0] == NO_CARD {
+			state.TakeAction(state.DecidePlayFirstFast())
+		} else {
+			state.TryWinTrick(p)
+		}
+	}
+}
+
+// Replay a game and re-search each decision from the mover's point of view
+// Flags moves scoring more than threshold points below the best
+func Analyze(initial *GameState, actions []Action, timeBudget int64, threshold float64) ([]Annotation, error) {
+	state := initial.Clone()
+	annots := make([]Annotation, 0, len(actions))
+	for i,act := range actions {
+		if !Includes(state.PlayerActions(act.Player), act) {
+			return annots, fmt.Errorf("Illegal action %d: %s", i, act.ToStr())
+		}
+		st := state.Clone()
+		st.Mask(act.Player)
+		annot := Annotation{Move: i, Action: act, Best: act}
+		if act.Verb == BidVerb {
+			est := st.DecideBids(act.Player, timeBudget)
+			annot.Score = est.BidValue(act.Bid)
+			annot.BestScore = annot.Score
+			for b := 0; b <= 13; b++ {
+				if v := est.BidValue(b); v > annot.BestScore {
+					annot.Best = Action{Verb: BidVerb, Player: act.Player, Card: act.Card, Bid: b}
+					annot.BestScore = v
+				}
+			}
+		} else {
+			cards, scores := st.ScoreCards(act.Player, timeBudget)
+			annot.BestScore = -1000
+			for j,c := range cards {
+				if c == act.Card {
+					annot.Score = scores[j]
+				}
+				if scores[j] > annot.BestScore {
+					annot.Best = Action{Verb: PlayVerb, Player: act.Player, Card: c}
+					annot.BestScore = scores[j]
+				}
+			}
+			// Ties go to the card played
+			if annot.Score == annot.BestScore {
+				annot.Best = act
+			}
+		}
+		annot.Blunder = annot.BestScore - annot.Score > threshold
+		annots = append(annots, annot)
+		state.TakeAction(act)
+	}
+	return annots, nil
+}
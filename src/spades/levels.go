@@ -0,0 +1,170 @@
+package spades
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Named bot strengths
+type Level struct {
+	Name string
+	// Milliseconds to think per bid and per card
+	BidBudget int64
+	PlayBudget int64
+	// Sampled deals per candidate card at most
+	MaxSims int
+	// Monte Carlo play, otherwise fast heuristics only
+	Simulate bool
+	// Solve double dummy over sampled deals with this many tricks left
+	DoubleDummyTricks int
+	// Chance of a random legal play or a bid off by one
+	MistakeRate float64
+	// Bid what we make with at least this probability
+	BidConfidence float64
+}
+
+var Levels = map[string]*Level{
+	"Easy": {Name: "Easy", BidBudget: 20, MistakeRate: 0.2, BidConfidence: 0.5},
+	"Medium": {Name: "Medium", BidBudget: 100, PlayBudget: 100, MaxSims: 25, Simulate: true, BidConfidence: 0.7},
+	"Hard": {Name: "Hard", BidBudget: 300, PlayBudget: 300, MaxSims: 80, Simulate: true, BidConfidence: 0.6},
+	"Expert": {Name: "Expert", BidBudget: 1000, PlayBudget: 500, MaxSims: 200, Simulate: true, DoubleDummyTricks: 5, BidConfidence: 0.6},
+}
+
+// Bot for a player type, "Computer" is the old name for Medium
+func LevelByName(name string) (*Level, bool) {
+	if name == "Computer" {
+		name = "Medium"
+	}
+	level, ok := Levels[name]
+	return level, ok
+}
+
+// Nil only if the level is that sure of taking no tricks, otherwise at least 1
+// Falling short of every confident bid isn't a reason to bid 0
+func (state *GameState) LevelBid(est BidEstimate, level *Level) int {
+	if state.MinBid() == 0 && est.Dist[0] >= level.BidConfidence {
+		return 0
+	}
+	return max(1, est.BidWithConfidence(level.BidConfidence))
+}
+
+// Bid or play for player at the given level
+// Returns false if it's not player's turn
+func (state *GameState) DecideAction(player int, level *Level) (Action, bool) {
+	acts := state.PlayerActions(player)
+	if len(acts) == 0 {
+		return Action{}, false
+	}
+	if rand.Float64() < level.MistakeRate {
+		if acts[0].Verb == BidVerb {
+			b := state.LevelBid(state.DecideBids(player, level.BidBudget), level)
+			// Never slip into nil by mistake
+			if b > 0 {
+				b = max(1, min(13, b + 2*rand.IntN(2) - 1))
+			}
+			return Action{Verb: BidVerb, Player: player, Card: NO_CARD, Bid: b}, true
+		}
+		return acts[rand.IntN(len(acts))], true
+	}
+	act, _, _, ok := state.Suggest(player, level)
+	return act, ok
+}
+
+// Best bid or play at the given level with a short reason and a score
+// Scores are the bid's make probability, the card's win rate,
+// or average double-dummy tricks
+func (state *GameState) Suggest(player int, level *Level) (Action, string, float64, bool) {
+	acts := state.PlayerActions(player)
+	if len(acts) == 0 {
+		return Action{}, "", 0, false
+	}
+	if acts[0].Verb == BidVerb {
+		est := state.DecideBids(player, level.BidBudget)
+		b := state.LevelBid(est, level)
+		p := est.MakeProbability(b)
+		reason := fmt.Sprintf("Expect %.1f tricks, %.0f%% to make %d", est.Mean, 100*p, b)
+		return Action{Verb: BidVerb, Player: player, Card: NO_CARD, Bid: b}, reason, p, true
+	}
+	if level.DoubleDummyTricks > 0 && state.TricksLeft() <= level.DoubleDummyTricks {
+		act, tricks := state.decidePlayDoubleDummy(player, level.PlayBudget)
+		if tricks >= 0 {
+			reason := fmt.Sprintf("Side takes %.1f more tricks with best play", tricks)
+			return act, reason, tricks, true
+		}
+		return act, "Quick heuristic", tricks, true
+	}
+	var act Action
+	rate := -1.0
+	first := state.Trick[0] == NO_CARD
+	if !level.Simulate && first {
+		act = state.DecidePlayFirstFast()
+	} else if !level.Simulate {
+		act = state.DecidePlayNotFirstFast()
+	} else if first {
+		act, rate = state.decidePlayFirst(level.PlayBudget, level.MaxSims)
+	} else {
+		act, rate = state.decidePlayNotFirst(level.PlayBudget, level.MaxSims)
+	}
+	reason := ""
+	switch {
+		case rate >= 0:
+			reason = fmt.Sprintf("Wins the trick in %.0f%% of deals", 100*rate)
+		case state.IsNil(player):
+			reason = "Bid nil, take no tricks"
+		case state.ContractMode(player) == AvoidBags:
+			reason = "Contract made, avoid bags"
+		case first:
+			reason = "Lead a card you can spare"
+		default:
+			reason = "Not worth trying to win, throw a low card"
+	}
+	return act, reason, rate, true
+}
+
+// Average double-dummy tricks for each card over sampled deals
+// Takes the most, or the fewest once extra tricks are only bags
+func (state *GameState) DecidePlayDoubleDummy(player int, timeBudget int64) Action {
+	act, _ := state.decidePlayDoubleDummy(player, timeBudget)
+	return act
+}
+
+// Also returns the average tricks for the chosen card
+func (state *GameState) decidePlayDoubleDummy(player int, timeBudget int64) (Action, float64) {
+	start := time.Now()
+	var cards []Card
+	var totals []int
+	sims := 0
+	for time.Since(start).Milliseconds() < timeBudget {
+		st := state.Clone()
+		if !st.SampleHands(player) {
+			break
+		}
+		cs, tricks := st.DoubleDummyCards(player)
+		if totals == nil {
+			cards = cs
+			totals = make([]int, len(cs))
+		}
+		for i := range tricks {
+			totals[i] += tricks[i]
+		}
+		sims++
+	}
+	if totals == nil {
+		return state.DecidePlayNotFirstFast(), -1
+	}
+	fewest := state.ContractMode(player) == AvoidBags
+	best := 0
+	for i := range cards {
+		better := totals[i] > totals[best]
+		if fewest {
+			better = totals[i] < totals[best]
+		}
+		// Cheaper card on ties
+		if better || (totals[i] == totals[best] && cards[i].Rank() < cards[best].Rank()) {
+			best = i
+		}
+	}
+	act := Action{Verb: PlayVerb, Player: player, Card: cards[best]}
+	return act, float64(totals[best]) / float64(sims)
+}
@@ -0,0 +1,339 @@
+package spades
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A hand of spades, written in the style of bridge PBN
+// Seats 0-3 are North, East, South and West, so NS are partners 0 and 2
+type Record struct {
+	// Names of humans, types of bots
+	Players [4]string
+	// Zero when the deal wasn't from a seed
+	Seed int64
+	// Bids and leads first
+	Dealer int
+	Deal [4][]Card
+	Actions []Action
+}
+
+// One trick in play order
+type TrickRecord struct {
+	Leader int
+	Cards []Card
+	// -1 until the trick is complete
+	Winner int
+}
+
+var seatNames = []string{"N", "E", "S", "W"}
+
+// PBN suit and rank order
+var pbnSuits = []string{"C", "S", "H", "D"}
+var pbnRanks = []string{"2", "3", "4", "5", "6", "7", "8", "9", "T", "J", "Q", "K", "A"}
+var pbnHandSuits = []int{SUIT_SPADES, 2, 3, 0}
+
+// Start recording a freshly dealt hand
+func NewRecord(state *GameState, players [4]string) *Record {
+	rec := &Record{Players: players, Dealer: state.Attacker, Actions: make([]Action, 0)}
+	for i,h := range state.Hands {
+		rec.Deal[i] = append(make([]Card, 0), h...)
+	}
+	return rec
+}
+
+// Take an action and record it
+func (rec *Record) TakeAction(state *GameState, act Action) {
+	state.TakeAction(act)
+	rec.Actions = append(rec.Actions, act)
+}
+
+// Position after the first n actions
+func (rec *Record) StateAt(n int) (*GameState, error) {
+	if n < 0 || n > len(rec.Actions) {
+		return nil, fmt.Errorf("Move %d out of range 0-%d", n, len(rec.Actions))
+	}
+	state := DealGameState(rec.Deal, rec.Dealer)
+	for i,act := range rec.Actions[:n] {
+		if !Includes(state.PlayerActions(act.Player), act) {
+			return nil, fmt.Errorf("Illegal action %d: %s", i, act.ToStr())
+		}
+		state.TakeAction(act)
+	}
+	return state, nil
+}
+
+// Replay the whole record
+func (rec *Record) Final() (*GameState, error) {
+	return rec.StateAt(len(rec.Actions))
+}
+
+// Tricks played so far with leaders and winners
+func (rec *Record) Tricks() []TrickRecord {
+	tricks := make([]TrickRecord, 0)
+	leader := rec.Dealer
+	var cur *TrickRecord
+	for _,act := range rec.Actions {
+		if act.Verb != PlayVerb {
+			continue
+		}
+		if cur == nil {
+			tricks = append(tricks, TrickRecord{Leader: leader, Cards: make([]Card, 0, 4), Winner: -1})
+			cur = &tricks[len(tricks)-1]
+		}
+		cur.Cards = append(cur.Cards, act.Card)
+		if len(cur.Cards) == 4 {
+			trick := Trick(cur.Cards)
+			cur.Winner = (trick.Winner() + leader) % 4
+			leader = cur.Winner
+			cur = nil
+		}
+	}
+	return tricks
+}
+
+// Suit letter then rank, e.g. ST for the ten of spades
+func (card Card) PBN() string {
+	return pbnSuits[card.Suit()] + pbnRanks[card.Rank()]
+}
+
+func ParsePBNCard(s string) (Card, error) {
+	if len(s) != 2 {
+		return NO_CARD, fmt.Errorf("Bad card %q", s)
+	}
+	suit := IndexOf(pbnSuits, s[:1])
+	rank := IndexOf(pbnRanks, s[1:])
+	if suit == -1 || rank == -1 {
+		return NO_CARD, fmt.Errorf("Bad card %q", s)
+	}
+	return CardFromRankSuit(rank, suit), nil
+}
+
+func IndexOf[T comparable](slice []T, val T) int {
+	for i,v := range slice {
+		if v == val {
+			return i
+		}
+	}
+	return -1
+}
+
+// Spades.hearts.diamonds.clubs, high cards first
+func handPBN(hand []Card) string {
+	parts := make([]string, 4)
+	for i,suit := range pbnHandSuits {
+		for r := 12; r >= 0; r-- {
+			if Includes(hand, CardFromRankSuit(r, suit)) {
+				parts[i] += pbnRanks[r]
+			}
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func parseHandPBN(s string) ([]Card, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("Bad hand %q", s)
+	}
+	hand := make([]Card, 0)
+	for i,part := range parts {
+		for _,ch := range part {
+			r := IndexOf(pbnRanks, string(ch))
+			if r == -1 {
+				return nil, fmt.Errorf("Bad hand %q", s)
+			}
+			hand = append(hand, CardFromRankSuit(r, pbnHandSuits[i]))
+		}
+	}
+	return hand, nil
+}
+
+// Tags, then the auction in bidding order,
+// then one trick per line as "leader: cards in play order -> winner"
+func (rec *Record) WritePBN(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "[Event \"Spades\"]\n")
+	fmt.Fprintf(bw, "[Seed \"%d\"]\n", rec.Seed)
+	for i,seat := range []string{"North", "East", "South", "West"} {
+		fmt.Fprintf(bw, "[%s %s]\n", seat, strconv.Quote(rec.Players[i]))
+	}
+	fmt.Fprintf(bw, "[Dealer \"%s\"]\n", seatNames[rec.Dealer])
+	hands := make([]string, 4)
+	for i := 0; i < 4; i++ {
+		hands[i] = handPBN(rec.Deal[(rec.Dealer+i)%4])
+	}
+	fmt.Fprintf(bw, "[Deal \"%s:%s\"]\n", seatNames[rec.Dealer], strings.Join(hands, " "))
+	if st, err := rec.Final(); err == nil && st.IsOver() {
+		fmt.Fprintf(bw, "[Tricks \"%d %d %d %d\"]\n", st.Tricks[0], st.Tricks[1], st.Tricks[2], st.Tricks[3])
+	}
+	fmt.Fprintf(bw, "[Auction \"%s\"]\n", seatNames[rec.Dealer])
+	bids := make([]string, 0)
+	for _,act := range rec.Actions {
+		if act.Verb == BidVerb {
+			bids = append(bids, strconv.Itoa(act.Bid))
+		}
+	}
+	fmt.Fprintln(bw, strings.Join(bids, " "))
+	fmt.Fprintf(bw, "[Play \"%s\"]\n", seatNames[rec.Dealer])
+	for _,t := range rec.Tricks() {
+		cards := make([]string, len(t.Cards))
+		for i,c := range t.Cards {
+			cards[i] = c.PBN()
+		}
+		fmt.Fprintf(bw, "%s: %s", seatNames[t.Leader], strings.Join(cards, " "))
+		if t.Winner != -1 {
+			fmt.Fprintf(bw, " -> %s", seatNames[t.Winner])
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// Reads a record written by WritePBN and checks it replays
+// to the recorded trick winners and totals
+func ParsePBN(r io.Reader) (*Record, error) {
+	rec := &Record{Actions: make([]Action, 0)}
+	scanner := bufio.NewScanner(r)
+	section := ""
+	var tricks []int
+	var winners []int
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			tag, val, ok := strings.Cut(strings.Trim(line, "[]"), " ")
+			if !ok {
+				return nil, fmt.Errorf("Bad tag %q", line)
+			}
+			val, err := strconv.Unquote(val)
+			if err != nil {
+				return nil, fmt.Errorf("Bad tag %q", line)
+			}
+			section = tag
+			switch tag {
+				case "Seed":
+					rec.Seed, err = strconv.ParseInt(val, 10, 64)
+				case "North", "East", "South", "West":
+					rec.Players[IndexOf(seatNames, tag[:1])] = val
+				case "Dealer":
+					rec.Dealer = IndexOf(seatNames, val)
+					if rec.Dealer == -1 {
+						err = fmt.Errorf("Bad dealer %q", val)
+					}
+				case "Deal":
+					err = rec.parseDeal(val)
+				case "Tricks":
+					for _,f := range strings.Fields(val) {
+						n, e := strconv.Atoi(f)
+						if e != nil {
+							err = e
+							break
+						}
+						tricks = append(tricks, n)
+					}
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch section {
+			case "Auction":
+				for _,f := range strings.Fields(line) {
+					bid, err := strconv.Atoi(f)
+					if err != nil {
+						return nil, fmt.Errorf("Bad bid %q", f)
+					}
+					p := (rec.Dealer + rec.numBids()) % 4
+					rec.Actions = append(rec.Actions, Action{Verb: BidVerb, Player: p, Card: NO_CARD, Bid: bid})
+				}
+			case "Play":
+				w, err := rec.parseTrick(line)
+				if err != nil {
+					return nil, err
+				}
+				winners = append(winners, w)
+			default:
+				return nil, fmt.Errorf("Unexpected line %q", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	st, err := rec.Final()
+	if err != nil {
+		return nil, err
+	}
+	for i,t := range rec.Tricks() {
+		if winners[i] != -1 && winners[i] != t.Winner {
+			return nil, fmt.Errorf("Trick %d won by %s, not %s", i+1, seatNames[t.Winner], seatNames[winners[i]])
+		}
+	}
+	if tricks != nil && (len(tricks) != 4 || [4]int(tricks) != st.Tricks) {
+		return nil, fmt.Errorf("Tricks %v don't match replay %v", tricks, st.Tricks)
+	}
+	return rec, nil
+}
+
+func (rec *Record) numBids() int {
+	n := 0
+	for _,act := range rec.Actions {
+		if act.Verb == BidVerb {
+			n++
+		}
+	}
+	return n
+}
+
+// First seat, then four hands clockwise
+func (rec *Record) parseDeal(val string) error {
+	first, hands, ok := strings.Cut(val, ":")
+	start := IndexOf(seatNames, first)
+	fields := strings.Fields(hands)
+	if !ok || start == -1 || len(fields) != 4 {
+		return fmt.Errorf("Bad deal %q", val)
+	}
+	for i,f := range fields {
+		hand, err := parseHandPBN(f)
+		if err != nil {
+			return err
+		}
+		rec.Deal[(start+i)%4] = hand
+	}
+	return nil
+}
+
+// Returns the written winner, -1 if the trick isn't finished
+func (rec *Record) parseTrick(line string) (int, error) {
+	seat, rest, ok := strings.Cut(line, ":")
+	leader := IndexOf(seatNames, seat)
+	if !ok || leader == -1 {
+		return -1, fmt.Errorf("Bad trick %q", line)
+	}
+	cards, win, hasWinner := strings.Cut(rest, "->")
+	fields := strings.Fields(cards)
+	if len(fields) == 0 || len(fields) > 4 {
+		return -1, fmt.Errorf("Bad trick %q", line)
+	}
+	for i,f := range fields {
+		c, err := ParsePBNCard(f)
+		if err != nil {
+			return -1, err
+		}
+		rec.Actions = append(rec.Actions, Action{Verb: PlayVerb, Player: (leader+i)%4, Card: c})
+	}
+	if !hasWinner {
+		return -1, nil
+	}
+	winner := IndexOf(seatNames, strings.TrimSpace(win))
+	if winner == -1 {
+		return -1, fmt.Errorf("Bad trick %q", line)
+	}
+	return winner, nil
+}
@@ -37,6 +37,10 @@ type GameState struct {
 	Trick Trick
 	// Known absent cards (according to each player)
 	Absent [4][4][52]bool
+	// Nobody may bid 0
+	NoNil bool
+	// A bid of 0 is just part of the side's contract, not nil
+	ZeroBids bool
 }
 
 var suits = []string{"clubs", "spades", "hearts", "diamonds"}
@@ -120,15 +124,38 @@ func (stack *Trick) Winner() int {
 }
 
 func InitGameState() *GameState {
+	return InitGameStateRand(nil)
+}
+
+// Same seed, same deal
+func InitGameStateSeed(seed int64) *GameState {
+	return InitGameStateRand(rand.New(rand.NewPCG(uint64(seed), 0)))
+}
+
+// Deal with rng, or the global source if nil
+func InitGameStateRand(rng *rand.Rand) *GameState {
 	hands := [4][]Card{}
 	for i := 0; i < 4; i++ {
 		hands[i] = make([]Card, 13)
 	}
-	deck := rand.Perm(52)
+	perm := rand.Perm
+	if rng != nil {
+		perm = rng.Perm
+	}
+	deck := perm(52)
 	for i := 0; i < 52; i++ {
 		player := i / 13
 		hands[player][i%13] = Card(deck[i])
 	}
+	return DealGameState(hands, 0)
+}
+
+// Start bidding from dealt hands, dealer bids and leads first
+func DealGameState(dealt [4][]Card, dealer int) *GameState {
+	hands := [4][]Card{}
+	for i,h := range dealt {
+		hands[i] = append(make([]Card, 0), h...)
+	}
 	for _,h := range hands {
 		sort.Slice(h, func (a int, b int) bool {
 			return h[a] < h[b]
@@ -140,7 +167,7 @@ func InitGameState() *GameState {
 		Hands: hands,
 		Bids: bids,
 		Tricks: tricks,
-		Attacker: 0,
+		Attacker: dealer,
 		PrevTrick: InitTrick(),
 		Trick: InitTrick(),
 	}
@@ -171,6 +198,8 @@ func (state *GameState) Clone() *GameState {
 		PrevTrick: state.PrevTrick,
 		Trick: state.Trick,
 		Absent: state.Absent,
+		NoNil: state.NoNil,
+		ZeroBids: state.ZeroBids,
 	}
 }
 
@@ -192,7 +221,7 @@ func (state *GameState) PlayerActions(player int) []Action {
 		j := (state.Attacker + i) % 4
 		if state.Bids[j] == -1 {
 			if player == j {
-				for k := 0; k <= 13; k++ {
+				for k := state.MinBid(); k <= 13; k++ {
 					acts = append(acts, Action{Verb: BidVerb, Player: player, Card: NO_CARD, Bid: k})
 				}
 			}
@@ -238,6 +267,14 @@ func (state *GameState) PlayerActions(player int) []Action {
 	return acts
 }
 
+// Lowest legal bid
+func (state *GameState) MinBid() int {
+	if state.NoNil {
+		return 1
+	}
+	return 0
+}
+
 func RemoveCard(cards *[]Card, c Card) bool {
     for i,card := range *cards {
         if card == c {
@@ -307,12 +344,15 @@ func (state *GameState) CurrentActions() []Action {
 	return acts
 }
 
+// Hide everything player can't know
+// Other players' knowledge includes their own hands
 func (state *GameState) Mask(player int) {
 	for i,hand := range state.Hands {
 		if i != player {
 			for j := 0; j < len(hand); j++ {
 				hand[j] = UNK_CARD;
 			}
+			state.Absent[i] = [4][52]bool{}
 		}
 	}
 }
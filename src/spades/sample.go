@@ -0,0 +1,109 @@
+package spades
+
+import (
+	"math/rand/v2"
+)
+
+// Cards still held by the other players, as far as player knows
+func (state *GameState) Unseen(player int) []Card {
+	unseen := make([]Card, 0)
+	for c := 0; c < 52; c++ {
+		if Includes(state.Hands[player], Card(c)) {
+			continue
+		}
+		for j := 0; j < 4; j++ {
+			if j != player && !state.Absent[player][j][c] {
+				unseen = append(unseen, Card(c))
+				break
+			}
+		}
+	}
+	return unseen
+}
+
+// Deal the unseen cards to the other players all at once
+// Every card goes to exactly one player who may still hold it
+// Deals are drawn uniformly from every assignment that fits
+// Keeps player's own hand, returns false only if no deal fits
+func (state *GameState) SampleHands(player int) bool {
+	unseen := state.Unseen(player)
+	others := make([]int, 0, 3)
+	for j := 0; j < 4; j++ {
+		if j != player {
+			others = append(others, j)
+		}
+	}
+	n0 := len(state.Hands[others[0]])
+	n1 := len(state.Hands[others[1]])
+	n2 := len(state.Hands[others[2]])
+	m := len(unseen)
+	if n0+n1+n2 != m {
+		return false
+	}
+	eligible := func (i int, k int) bool {
+		return !state.Absent[player][others[k]][unseen[i]]
+	}
+	// ways[i][a][b] counts deals of cards i and up where the first two
+	// players still take a and b cards and the third takes the rest
+	stride := (n0+1)*(n1+1)
+	idx := func (i int, a int, b int) int {
+		return i*stride + a*(n1+1) + b
+	}
+	ways := make([]float64, (m+1)*stride)
+	ways[idx(m, 0, 0)] = 1
+	weights := func (i int, a int, b int) [3]float64 {
+		w := [3]float64{}
+		rest := m - i - a - b
+		if a > 0 && eligible(i, 0) {
+			w[0] = ways[idx(i+1, a-1, b)]
+		}
+		if b > 0 && eligible(i, 1) {
+			w[1] = ways[idx(i+1, a, b-1)]
+		}
+		if rest > 0 && eligible(i, 2) {
+			w[2] = ways[idx(i+1, a, b)]
+		}
+		return w
+	}
+	for i := m-1; i >= 0; i-- {
+		for a := 0; a <= n0; a++ {
+			for b := 0; b <= n1 && a+b <= m-i; b++ {
+				w := weights(i, a, b)
+				ways[idx(i, a, b)] = w[0] + w[1] + w[2]
+			}
+		}
+	}
+	if ways[idx(0, n0, n1)] == 0 {
+		return false
+	}
+	// Walk forward choosing each card's holder in proportion to completions
+	hands := [3][]Card{make([]Card, 0, n0), make([]Card, 0, n1), make([]Card, 0, n2)}
+	a := n0
+	b := n1
+	for i := 0; i < m; i++ {
+		w := weights(i, a, b)
+		r := rand.Float64() * (w[0] + w[1] + w[2])
+		k := 2
+		if r < w[0] {
+			k = 0
+		} else if r < w[0] + w[1] {
+			k = 1
+		} else if w[2] == 0 {
+			// Rounding at the edge
+			k = 1
+			if w[1] == 0 {
+				k = 0
+			}
+		}
+		hands[k] = append(hands[k], unseen[i])
+		if k == 0 {
+			a--
+		} else if k == 1 {
+			b--
+		}
+	}
+	for k,j := range others {
+		state.Hands[j] = hands[k]
+	}
+	return true
+}
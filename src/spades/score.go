@@ -0,0 +1,63 @@
+package spades
+
+// Points and overtricks a side has built up over hands
+type Score struct {
+	Points int
+	Bags int
+}
+
+// Won or lost by a nil bid
+var NilBonus = 100
+
+// Every BagLimit overtricks cost BagPenalty points
+var BagLimit = 10
+var BagPenalty = 100
+
+// Add a finished hand to both sides' scores
+// Seats 0 and 2 are side 0, seats 1 and 3 side 1
+// A side makes 10 a trick bid and 1 a trick over, or loses 10 a trick bid
+// With nilBids a bid of 0 scores on its own and the bidder's tricks are bags
+func (state *GameState) ScoreHand(scores [2]Score, nilBids bool) [2]Score {
+	for side := 0; side < 2; side++ {
+		bid, tricks, bags := 0, 0, 0
+		sc := &scores[side]
+		for _,p := range []int{side, side+2} {
+			if nilBids && state.Bids[p] == 0 {
+				if state.Tricks[p] == 0 {
+					sc.Points += NilBonus
+				} else {
+					sc.Points -= NilBonus
+					bags += state.Tricks[p]
+				}
+				continue
+			}
+			bid += state.Bids[p]
+			tricks += state.Tricks[p]
+		}
+		if tricks >= bid {
+			sc.Points += 10*bid
+			bags += tricks - bid
+		} else {
+			sc.Points -= 10*bid
+		}
+		sc.Points += bags
+		sc.Bags += bags
+		for sc.Bags >= BagLimit {
+			sc.Points -= BagPenalty
+			sc.Bags -= BagLimit
+		}
+	}
+	return scores
+}
+
+// Side that reached target ahead of the other, -1 while nobody has
+func Winner(scores [2]Score, target int) int {
+	a, b := scores[0].Points, scores[1].Points
+	switch {
+		case a >= target && a > b:
+			return 0
+		case b >= target && b > a:
+			return 1
+	}
+	return -1
+}
@@ -0,0 +1,91 @@
+package spades
+
+import (
+	"github.com/aorliche/cards-ai/search"
+)
+
+// Spades as a generic search.GameState
+var _ search.GameState = (*GameState)(nil)
+
+func (state *GameState) NumPlayers() int {
+	return 4
+}
+
+func (state *GameState) Debug(player int) []int {
+	ints := make([]int, 0)
+	for _,c := range state.Hands[player] {
+		ints = append(ints, int(c))
+	}
+	return ints
+}
+
+// Value of a partnership's contract as it stands
+// Made bids count in full plus a point per bag, set bids count against
+func (state *GameState) ContractValue(player int) float64 {
+	partner := (player+2)%4
+	tricks := state.Tricks[player] + state.Tricks[partner]
+	if state.Bids[player] == -1 || state.Bids[partner] == -1 {
+		return 10*float64(tricks)
+	}
+	bid := state.Bids[player] + state.Bids[partner]
+	if tricks >= bid {
+		return 10*float64(bid) + float64(tricks-bid)
+	}
+	if tricks + state.TricksLeft() < bid {
+		return -10*float64(bid)
+	}
+	return 10*float64(tricks)
+}
+
+func (state *GameState) Eval(player int) float64 {
+	return state.ContractValue(player) - state.ContractValue((player+1)%4)
+}
+
+func (state *GameState) Children(player int) ([]search.Action, []search.GameState) {
+	if state.IsOver() {
+		return make([]search.Action, 0), make([]search.GameState, 0)
+	}
+	acts := state.PlayerActions(player)
+	searchActs := make([]search.Action, len(acts))
+	children := make([]search.GameState, len(acts))
+	for i,a := range acts {
+		st := state.Clone()
+		st.TakeAction(a)
+		searchActs[i] = a
+		children[i] = st
+	}
+	return searchActs, children
+}
+
+// Search several deals consistent with what player knows
+// and play the action chosen most often
+func (state *GameState) FindBestAction(player int, depth int, timeBudget int64) (Action, bool) {
+	acts := state.PlayerActions(player)
+	if len(acts) == 0 {
+		return Action{}, false
+	}
+	// Searching can't tell bids apart before any cards are played
+	if acts[0].Verb == BidVerb {
+		b := state.DecideBids(player, timeBudget).Bid
+		return Action{Verb: BidVerb, Player: player, Card: NO_CARD, Bid: b}, true
+	}
+	nDeals := 5
+	votes := make(map[Action]int)
+	var best Action
+	for i := 0; i < nDeals; i++ {
+		st := state.Clone()
+		if !st.SampleHands(player) {
+			continue
+		}
+		iface, _ := search.SearchItDeep(st, player, depth, timeBudget/int64(nDeals))
+		act, ok := iface.(Action)
+		if !ok {
+			continue
+		}
+		votes[act]++
+		if votes[act] > votes[best] {
+			best = act
+		}
+	}
+	return best, votes[best] > 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/aorliche/cards-ai/spades"
+)
+
+// Rule options sent as Data with New, every field optional
+type Config struct {
+	// Deal hands until a side has this many points, 0 for a single hand
+	Target int
+	// How a bid of 0 counts, one of Nils
+	Nil string
+	// Level of Computer seats and of bots playing for humans who leave
+	Bot string
+}
+
+// Nil scores 100 if the bidder takes no tricks and loses 100 if they take any
+// Zero is just part of the side's contract
+// NoNil bids start at 1
+var Nils = []string{"Nil", "Zero", "NoNil"}
+
+var MinTarget = 100
+var MaxTarget = 1000
+
+var DefaultConfig = Config{Target: 0, Nil: "Nil", Bot: "Medium"}
+
+// Defaults for missing fields, errors say what's wrong with the rest
+func ParseConfig(data string) (Config, error) {
+	config := DefaultConfig
+	if data == "" {
+		return config, nil
+	}
+	dec := json.NewDecoder(bytes.NewBufferString(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return config, fmt.Errorf("Bad Spades options: %v", err)
+	}
+	if config.Target != 0 && (config.Target < MinTarget || config.Target > MaxTarget) {
+		return config, fmt.Errorf("Target must be 0 for a single hand or from %d to %d, not %d", MinTarget, MaxTarget, config.Target)
+	}
+	if !slices.Contains(Nils, config.Nil) {
+		return config, fmt.Errorf("Nil must be one of %v, not %q", Nils, config.Nil)
+	}
+	if _, ok := spades.LevelByName(config.Bot); !ok {
+		return config, fmt.Errorf("Unknown bot level %s", config.Bot)
+	}
+	return config, nil
+}
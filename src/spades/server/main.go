@@ -4,8 +4,12 @@ import(
 	"bytes"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -20,6 +24,21 @@ type GameState struct {
 	Player int
 	Names []string
 	Actions []spades.Action
+	// Double-dummy tricks for each side, shown after the hand
+	Par *Par
+	// Deal seed to reproduce the hand, only once it's over
+	// since it rebuilds every hidden hand
+	Seed int64
+	// Totals after the hands played so far, by side
+	Scores [2]spades.Score
+	// Finished hands
+	Played int
+	// Side that reached the target, -1 until then
+	Winner int
+	// Only set when replaying a finished hand
+	Replay *server.ReplayPos
+	// Only set for spectators
+	Spectator *server.SpectatorView
 }
 
 type Game struct {
@@ -28,15 +47,92 @@ type Game struct {
 	Players []*server.Player
 	// The actual game state
 	State *GameState
+	// Hand being played, the last of records
+	Record *spades.Record
 	Terminated bool
+	Config Config
+	// Every hand dealt, with its par once the hand is over
+	records []*spades.Record
+	pars []*Par
+	// Closed to stop the bot playing a seat
+	stops []chan struct{}
+	// Only for exhibitions
+	pacer *server.Pacer
+}
+
+// Directory for finished hand records, none saved if empty
+var recordDir = ""
+
+// Every move goes through here to be recorded
+func (game *Game) takeAction(act spades.Action) {
+	game.Record.TakeAction(&game.State.GameState, act)
+	sumTricks := 0
+	for i := 0; i < 4; i++ {
+		sumTricks += game.State.Tricks[i]
+	}
+	log.Println(sumTricks, act.ToStr())
+	if !game.State.IsOver() {
+		return
+	}
+	if recordDir != "" {
+		game.saveRecord()
+	}
+	game.solvePar()
+	game.State.Scores = game.State.GameState.ScoreHand(game.State.Scores, game.Config.Nil == "Nil")
+	game.State.Played++
+	if game.Config.Target == 0 {
+		return
+	}
+	game.State.Winner = spades.Winner(game.State.Scores, game.Config.Target)
+	if game.State.Winner == -1 {
+		// Last trick stays on show
+		last, leader := game.State.PrevTrick, game.State.PrevAttacker
+		game.deal((game.Record.Dealer + 1) % 4)
+		game.State.PrevTrick, game.State.PrevAttacker = last, leader
+	}
+}
+
+// Humans by name, bots by type
+func (game *Game) names() []string {
+	names := make([]string, len(game.Players))
+	for i,p := range game.Players {
+		if p.Type == "Human" {
+			names[i] = p.Name
+		} else {
+			names[i] = p.Type
+		}
+	}
+	return names
+}
+
+func (game *Game) saveRecord() {
+	// Humans may have joined after the deal
+	game.Record.Players = [4]string(game.names())
+	name := fmt.Sprintf("spades-%d.pbn", game.Key)
+	if game.Config.Target != 0 {
+		name = fmt.Sprintf("spades-%d-%d.pbn", game.Key, game.State.Played+1)
+	}
+	f, err := os.Create(filepath.Join(recordDir, name))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	if err := game.Record.WritePBN(f); err != nil {
+		log.Println(err)
+	}
 }
 
 func CreateGame() server.Game {
-	return &Game{Players: make([]*server.Player, 0)}
+	return &Game{Players: make([]*server.Player, 0), Config: DefaultConfig}
 }
 
+// Also takes every bot off the table
 func (game *Game) Terminate() {
 	game.Terminated = true
+	for i := range game.stops {
+		game.StopBot(i)
+	}
 }
 
 func (game *Game) GetKey() int {
@@ -56,10 +152,18 @@ func (game *Game) HasOpenSlots() bool {
 	return false
 }
 
+// Nothing is dealt while players wait in the room
 func (game *Game) IsOver() bool {
-	return game.State.IsOver() || game.Terminated
+	return game.Terminated || (game.State != nil && game.State.IsOver())
 }
 	
+// IsOver for bots, which run without the lock
+func (game *Game) over() bool {
+	game.Lock()
+	defer game.Unlock()
+	return game.IsOver()
+}
+
 func (game *Game) AddPlayer(player server.Player) {
 	game.Players = append(game.Players, &player)
 }
@@ -68,68 +172,230 @@ func (game *Game) GetPlayers() []*server.Player {
 	return game.Players
 }
 
-func (game *Game) Init(string) error {
+func (game *Game) Configure(data string) error {
+	config, err := ParseConfig(data)
+	if err != nil {
+		return err
+	}
+	game.Config = config
+	return nil
+}
+
+// Computer seats play at the configured level
+func (game *Game) level(typ string) (*spades.Level, error) {
+	if typ == "Computer" {
+		typ = game.Config.Bot
+	}
+	level, ok := spades.LevelByName(typ)
+	if !ok {
+		return nil, fmt.Errorf("Unknown player type %s", typ)
+	}
+	return level, nil
+}
+
+func (game *Game) Init(data string) error {
+	if err := game.Configure(data); err != nil {
+		return err
+	}
 	n := len(game.Players)
 	if n != 4 {
 		return errors.New("Bad number of players for Spades")
 	}
-	game.State = &GameState{*spades.InitGameState(), 0, nil, nil}
-	// AI Logic
-	aiFunc := func (player int) {
-		for !game.IsOver() {
-			time.Sleep(200 * time.Millisecond)
-			game.Lock()
-			st := game.State.Clone()
-			game.Unlock()
-			if game.IsOver() {
-				break
-			}
-			var act spades.Action
-			if st.Bids[player] == -1 && len(st.PlayerActions(player)) > 0 {
-				b := st.DecideBids(player, 100)
-				// Computers are conservative
-				if b > 0 {
-					b--
-				}
-				act = spades.Action{Verb: spades.BidVerb, Player: player, Bid: b, Card: spades.NO_CARD}
-			} else if st.Trick[0] == spades.NO_CARD && st.Attacker == player {
-				if st.PrevTrick[0] != spades.NO_CARD {
-					time.Sleep(2000 * time.Millisecond)
-				}
-				act = st.DecidePlayFirst(100)
-			} else {
-				i := (player + 4 - st.Attacker) % 4
-				j := (i + 4 - 1) % 4
-				if st.Trick[i] == spades.NO_CARD && st.Trick[j] != spades.NO_CARD {
-					act = st.DecidePlayNotFirst(100)
-				}
-			}
-			game.Lock()
-			acts := game.State.PlayerActions(player)
-			for _,a := range acts {
-				if a == act {
-					game.State.TakeAction(act)
-					sumTricks := 0
-					for i := 0; i < 4; i++ {
-						sumTricks += game.State.Tricks[i]
-					}
-					log.Println(sumTricks, act.ToStr())
-					server.UpdatePlayers(game)
+	// Bots for non-human players
+	levels := make([]*spades.Level, n)
+	for i,p := range game.Players {
+		if p.Type == "Human" {
+			continue
+		}
+		level, err := game.level(p.Type)
+		if err != nil {
+			return err
+		}
+		levels[i] = level
+	}
+	game.State = &GameState{Winner: -1}
+	game.deal(0)
+	// Start AI players
+	game.stops = make([]chan struct{}, n)
+	for i,level := range levels {
+		if level != nil {
+			game.startBot(i, level)
+		}
+	}
+	return nil
+}
+
+// Next hand, keeping the scores
+func (game *Game) deal(dealer int) {
+	seed := server.NewSeed()
+	st := spades.DealGameState(spades.InitGameStateSeed(seed).Hands, dealer)
+	st.NoNil = game.Config.Nil == "NoNil"
+	st.ZeroBids = game.Config.Nil == "Zero"
+	game.State.GameState = *st
+	game.Record = spades.NewRecord(st, [4]string(game.names()))
+	game.Record.Seed = seed
+	game.records = append(game.records, game.Record)
+	game.pars = append(game.pars, nil)
+}
+
+// Par is optional, Tricks only count once it's Solved
+type Par struct {
+	Status string
+	Tricks [2]int
+}
+
+const (
+	ParSolving = "Solving"
+	ParSolved = "Solved"
+	// Searched too long
+	ParGaveUp = "GaveUp"
+	// Every search slot was taken when the hand ended
+	ParBusy = "Busy"
+)
+
+// Par searches at once, each takes seconds and plenty of memory
+// Hands that end while all are taken go without
+var ParSolves = 2
+var parSlots = make(chan struct{}, ParSolves)
+
+// Solve the hand just finished in the background
+// Call with the game locked
+func (game *Game) solvePar() {
+	hand, rec := len(game.records)-1, game.Record
+	par := &Par{Status: ParSolving}
+	game.pars[hand] = par
+	select {
+		case parSlots <- struct{}{}:
+		default:
+			par.Status = ParBusy
+			return
+	}
+	go func () {
+		defer func () { <-parSlots }()
+		// Nobody left to show it to
+		game.Lock()
+		terminated := game.Terminated
+		game.Unlock()
+		if terminated {
+			return
+		}
+		tricks, ok := spades.ParResult(rec.Deal, rec.Dealer)
+		game.Lock()
+		if ok {
+			par.Tricks, par.Status = tricks, ParSolved
+		} else {
+			log.Println("Gave up on par for game", game.Key)
+			par.Status = ParGaveUp
+		}
+		// Still on show
+		if game.Record == rec && game.State.IsOver() {
+			server.UpdatePlayers(game)
+		}
+		game.Unlock()
+	}()
+}
+
+// AI Logic, plays for player until the game ends or stop closes
+func (game *Game) runBot(player int, level *spades.Level, stop chan struct{}) {
+	for !game.over() {
+		time.Sleep(200 * time.Millisecond)
+		if game.pacer != nil && !game.pacer.Ready() {
+			continue
+		}
+		game.Lock()
+		st := game.State.Clone()
+		game.Unlock()
+		if game.over() {
+			break
+		}
+		select {
+			case <-stop:
+				return
+			default:
+		}
+		if len(st.PlayerActions(player)) == 0 {
+			continue
+		}
+		// Give humans a look at the last trick before leading
+		if st.Trick[0] == spades.NO_CARD && st.PrevTrick[0] != spades.NO_CARD {
+			time.Sleep(2000 * time.Millisecond)
+		}
+		st.Mask(player)
+		act, ok := st.DecideAction(player, level)
+		if !ok {
+			continue
+		}
+		game.Lock()
+		// Seat handed back while thinking
+		select {
+			case <-stop:
+				game.Unlock()
+				return
+			default:
+		}
+		acts := game.State.PlayerActions(player)
+		for _,a := range acts {
+			if a == act {
+				if game.pacer != nil && !game.pacer.Take() {
 					break
 				}
+				game.takeAction(act)
+				server.UpdatePlayers(game)
+				break
 			}
-			game.Unlock()
 		}
+		game.Unlock()
 	}
-	// Start AI players
-	for i,p := range game.Players {
-		if p.Type == "Computer" {
-			go aiFunc(i)
-		}
+}
+
+func (game *Game) startBot(seat int, level *spades.Level) {
+	stop := make(chan struct{})
+	game.stops[seat] = stop
+	go game.runBot(seat, level, stop)
+}
+
+// Bot plays a seat, Computer if typ is empty
+func (game *Game) StartBot(seat int, typ string) error {
+	if typ == "" {
+		typ = "Computer"
+	}
+	level, err := game.level(typ)
+	if err != nil {
+		return err
+	}
+	if game.stops == nil {
+		return server.ErrNotStarted
+	}
+	if game.stops[seat] != nil {
+		return fmt.Errorf("Seat %d already has a bot", seat)
 	}
+	game.startBot(seat, level)
 	return nil
 }
 
+func (game *Game) GetType() string {
+	return "Spades"
+}
+
+func (game *Game) GetOptions() any {
+	return game.Config
+}
+
+func (game *Game) Pace(pacer *server.Pacer) {
+	game.pacer = pacer
+}
+
+func (game *Game) StopBot(seat int) {
+	// Not dealt yet
+	if game.stops == nil {
+		return
+	}
+	if game.stops[seat] != nil {
+		close(game.stops[seat])
+		game.stops[seat] = nil
+	}
+}
+
 func (game *Game) Join(string) error {
 	return nil
 }
@@ -141,28 +407,122 @@ func (game *Game) Action(data string) error {
 	actions := game.State.PlayerActions(act.Player)
 	for _,a := range actions {
 		if a == act {
-			game.State.TakeAction(act)
+			game.takeAction(act)
 			return nil
 		}
 	}
 	return errors.New("Invalid action")
 }
 
+// Hard bot's choice from what player can see
+func (game *Game) Hint(player int) (string, error) {
+	game.Lock()
+	st := game.State.Clone()
+	game.Unlock()
+	st.Mask(player)
+	act, reason, score, ok := st.Suggest(player, spades.Levels["Hard"])
+	if !ok {
+		return "", errors.New("Nothing to suggest right now")
+	}
+	data, err := json.Marshal(server.Hint{Action: act, Reason: reason, Score: score})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 func (game *Game) GetState(player int) (string, error) {
+	sav := game.State.Clone()
+	game.State.Mask(player)
 	// Set player
 	game.State.Player = player
 	// Add player names
-	game.State.Names = make([]string, len(game.Players))
-	for i,p := range game.Players {
-		if p.Type == "Human" {
-			game.State.Names[i] = p.Name
-		} else {
-			game.State.Names[i] = p.Type
-		}
-	}
+	game.State.Names = game.names()
 	// Get player actions
 	game.State.Actions = game.State.PlayerActions(player)
+	// Par only once the hand is over
+	game.State.Par = nil
+	game.State.Seed = game.seed()
+	if game.State.IsOver() {
+		game.State.Par = game.pars[len(game.pars)-1]
+	}
 	data, err := json.Marshal(*game.State)
+	game.State.GameState = *sav
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Zero while any hand is still hidden
+func (game *Game) seed() int64 {
+	if game.State.IsOver() {
+		return game.Record.Seed
+	}
+	return 0
+}
+
+// Position after the first move actions from the record of a hand
+func (game *Game) Replay(hand int, move int, seat int) (string, error) {
+	if hand < 0 || hand >= len(game.records) {
+		return "", fmt.Errorf("No hand %d", hand)
+	}
+	if seat < -1 || seat >= len(game.Players) {
+		return "", fmt.Errorf("No seat %d", seat)
+	}
+	rec := game.records[hand]
+	st, err := rec.StateAt(move)
+	if err != nil {
+		return "", err
+	}
+	player := seat
+	if seat == -1 {
+		player = 0
+	} else {
+		st.Mask(seat)
+	}
+	state := GameState{
+		GameState: *st,
+		Player: player,
+		Names: game.names(),
+		Actions: make([]spades.Action, 0),
+		Seed: rec.Seed,
+		Scores: game.State.Scores,
+		Played: game.State.Played,
+		Winner: game.State.Winner,
+		Replay: &server.ReplayPos{Hand: hand, Hands: len(game.records), Move: move, Moves: len(rec.Actions), Seat: seat},
+	}
+	if st.IsOver() {
+		state.Par = game.pars[hand]
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Table as spectators see it, from seat 0
+func (game *Game) Spectate(open bool) (string, error) {
+	st := game.State.Clone()
+	if !open {
+		st.Mask(-1)
+	}
+	state := GameState{
+		GameState: *st,
+		Player: 0,
+		Names: game.names(),
+		Actions: make([]spades.Action, 0),
+		Seed: game.seed(),
+		Scores: game.State.Scores,
+		Played: game.State.Played,
+		Winner: game.State.Winner,
+		Spectator: &server.SpectatorView{Open: open},
+	}
+	if st.IsOver() {
+		state.Par = game.pars[len(game.pars)-1]
+	}
+	data, err := json.Marshal(state)
 	if err != nil {
 		return "", err
 	}
@@ -170,6 +530,13 @@ func (game *Game) GetState(player int) (string, error) {
 }
 
 func main() {
+    flag.StringVar(&recordDir, "records", "", "directory to save finished hand records")
+    flag.BoolVar(&server.BotTakeover, "takeover", false, "let a bot play for disconnected humans")
+    flag.IntVar(&server.MaxGames, "maxgames", server.MaxGames, "most games in progress at once, 0 for no limit")
+    flag.DurationVar(&server.IdleTimeout, "idle", server.IdleTimeout, "abandon games with no moves for this long")
+    flag.IntVar(&ParSolves, "parsolves", ParSolves, "par searches running at once, 0 to skip par")
+    flag.Parse()
+    parSlots = make(chan struct{}, ParSolves)
     log.SetFlags(0)
     server.ServeLocalFiles([]string{
 		"/home/anton/GitHub/cards-ai/static/cards/fronts",
@@ -188,6 +555,7 @@ func main() {
 		"/css",
 	})
 	server.CreateGameFunc = CreateGame
+	server.StartReaper(time.Minute)
     http.HandleFunc("/ws", server.Socket)
     log.Fatal(http.ListenAndServe(":8004", nil))
 }
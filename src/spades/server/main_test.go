@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	assert "gotest.tools/v3/assert"
+
+	"github.com/aorliche/cards-ai/server"
+	"github.com/aorliche/cards-ai/spades"
+)
+
+// Par searches for every finished test hand would slow the rest down
+func init() {
+	spades.ParNodes = 100000
+}
+
+func TestGetStateHidesOpponents(t *testing.T) {
+	game := CreateGame()
+	for i := 0; i < 4; i++ {
+		game.AddPlayer(server.Player{Name: "", Type: "Human", Joined: true})
+	}
+	assert.NilError(t, game.Init(""))
+	state := game.(*Game).State
+	state.Bids = [4]int{2,3,2,3}
+	state.TakeAction(state.PlayerActions(state.Attacker)[0])
+	for p := 0; p < 4; p++ {
+		data, err := game.GetState(p)
+		assert.NilError(t, err)
+		var st GameState
+		assert.NilError(t, json.Unmarshal([]byte(data), &st))
+		assert.DeepEqual(t, st.Hands[p], state.Hands[p])
+		// The seed would give every hand away
+		assert.Equal(t, st.Seed, int64(0))
+		for i := 0; i < 4; i++ {
+			if i == p {
+				continue
+			}
+			assert.Equal(t, len(st.Hands[i]), len(state.Hands[i]))
+			for _,c := range st.Hands[i] {
+				assert.Equal(t, c, spades.UNK_CARD)
+			}
+			assert.Equal(t, st.Absent[i], [4][52]bool{}, "player %v sees absent cards of %v", p, i)
+		}
+		for _,a := range st.Actions {
+			assert.Equal(t, a.Player, p)
+		}
+	}
+	// Masking doesn't touch the real game
+	for i := 0; i < 4; i++ {
+		for _,c := range state.Hands[i] {
+			assert.Assert(t, c >= 0)
+		}
+	}
+}
+
+func TestSpectate(t *testing.T) {
+	game := CreateGame()
+	for i := 0; i < 4; i++ {
+		game.AddPlayer(server.Player{Name: "", Type: "Human", Joined: true})
+	}
+	assert.NilError(t, game.Init(""))
+	state := game.(*Game).State
+	var st GameState
+	data, err := game.Spectate(false)
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal([]byte(data), &st))
+	assert.Equal(t, len(st.Actions), 0)
+	assert.Equal(t, st.Spectator.Open, false)
+	assert.Equal(t, st.Seed, int64(0))
+	for i := 0; i < 4; i++ {
+		for _,c := range st.Hands[i] {
+			assert.Equal(t, c, spades.UNK_CARD)
+		}
+	}
+	data, err = game.Spectate(true)
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal([]byte(data), &st))
+	assert.Equal(t, st.Spectator.Open, true)
+	assert.DeepEqual(t, st.Hands, state.Hands)
+}
+
+func TestInitUnknownPlayerType(t *testing.T) {
+	game := CreateGame()
+	game.AddPlayer(server.Player{Name: "", Type: "Human", Joined: true})
+	for i := 0; i < 3; i++ {
+		game.AddPlayer(server.Player{Name: "", Type: "Grandmaster", Joined: true})
+	}
+	assert.ErrorContains(t, game.Init(""), "Unknown player type")
+}
+
+func TestHint(t *testing.T) {
+	game := CreateGame()
+	for i := 0; i < 4; i++ {
+		game.AddPlayer(server.Player{Name: "", Type: "Human", Joined: true})
+	}
+	assert.NilError(t, game.Init(""))
+	state := game.(*Game).State
+	data, err := game.Hint(state.Attacker)
+	assert.NilError(t, err)
+	var hint struct {
+		Action spades.Action
+		Reason string
+		Score float64
+	}
+	assert.NilError(t, json.Unmarshal([]byte(data), &hint))
+	assert.Equal(t, hint.Action.Verb, spades.BidVerb)
+	assert.Equal(t, hint.Action.Player, state.Attacker)
+	assert.Assert(t, hint.Reason != "")
+	// Not this player's turn
+	_, err = game.Hint((state.Attacker+1)%4)
+	assert.ErrorContains(t, err, "Nothing to suggest")
+}
+
+func TestReplay(t *testing.T) {
+	game := CreateGame()
+	for i := 0; i < 4; i++ {
+		game.AddPlayer(server.Player{Name: "", Type: "Human", Joined: true})
+	}
+	assert.NilError(t, game.Init(""))
+	g := game.(*Game)
+	deal := g.State.Clone()
+	for !g.State.IsOver() {
+		acts := g.State.CurrentActions()
+		g.takeAction(acts[0])
+	}
+	var st GameState
+	data, err := game.GetState(0)
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal([]byte(data), &st))
+	assert.Equal(t, st.Seed, g.Record.Seed)
+	data, err = game.Replay(0, 0, -1)
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal([]byte(data), &st))
+	assert.DeepEqual(t, st.Hands, deal.Hands)
+	assert.Equal(t, st.Replay.Moves, 56)
+	// Masked from seat 2 halfway through
+	data, err = game.Replay(0, 30, 2)
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal([]byte(data), &st))
+	assert.Equal(t, st.Player, 2)
+	for _,c := range st.Hands[1] {
+		assert.Equal(t, c, spades.UNK_CARD)
+	}
+	for _,c := range st.Hands[2] {
+		assert.Assert(t, c >= 0)
+	}
+	data, err = game.Replay(0, 56, -1)
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal([]byte(data), &st))
+	assert.Equal(t, st.Tricks, g.State.Tricks)
+	_, err = game.Replay(0, 57, -1)
+	assert.ErrorContains(t, err, "out of range")
+	_, err = game.Replay(0, 0, 4)
+	assert.ErrorContains(t, err, "No seat")
+}
+
+func TestStartStopBot(t *testing.T) {
+	game := CreateGame()
+	for i := 0; i < 4; i++ {
+		game.AddPlayer(server.Player{Name: "", Type: "Human", Joined: true})
+	}
+	assert.NilError(t, game.Init(""))
+	assert.NilError(t, game.StartBot(0, ""))
+	assert.ErrorContains(t, game.StartBot(0, "Easy"), "already has a bot")
+	assert.ErrorContains(t, game.StartBot(1, "Grandmaster"), "Unknown player type")
+	game.StopBot(0)
+	game.StopBot(0)
+	assert.NilError(t, game.StartBot(0, "Easy"))
+	game.Lock()
+	game.Terminate()
+	game.Unlock()
+}
+
+func TestPacedBots(t *testing.T) {
+	game := CreateGame()
+	for i := 0; i < 4; i++ {
+		game.AddPlayer(server.Player{Name: "", Type: "Easy", Joined: true})
+	}
+	pacer := server.NewPacer(0)
+	pacer.Pause()
+	game.Pace(pacer)
+	assert.NilError(t, game.Init(""))
+	moves := func () int {
+		game.Lock()
+		defer game.Unlock()
+		return len(game.(*Game).Record.Actions)
+	}
+	time.Sleep(500*time.Millisecond)
+	assert.Equal(t, moves(), 0)
+	pacer.Step()
+	time.Sleep(500*time.Millisecond)
+	assert.Equal(t, moves(), 1)
+	pacer.Resume()
+	time.Sleep(500*time.Millisecond)
+	assert.Assert(t, moves() > 1)
+	game.Lock()
+	game.Terminate()
+	game.Unlock()
+}
+
+// With every search slot taken the hand just goes without par
+func TestParBusy(t *testing.T) {
+	for i := 0; i < cap(parSlots); i++ {
+		parSlots <- struct{}{}
+	}
+	defer func () {
+		for len(parSlots) > 0 {
+			<-parSlots
+		}
+	}()
+	game := CreateGame()
+	for i := 0; i < 4; i++ {
+		game.AddPlayer(server.Player{Name: "", Type: "Human", Joined: true})
+	}
+	assert.NilError(t, game.Init(""))
+	g := game.(*Game)
+	for !g.State.IsOver() {
+		data, err := json.Marshal(g.State.CurrentActions()[0])
+		assert.NilError(t, err)
+		assert.NilError(t, game.Action(string(data)))
+	}
+	var st GameState
+	data, err := game.GetState(0)
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal([]byte(data), &st))
+	assert.Equal(t, st.Par.Status, ParBusy)
+}
+
+func TestConfig(t *testing.T) {
+	for data, msg := range map[string]string{
+		`{"Target": 50}`: "Target must be 0 for a single hand",
+		`{"Nil": "Blind"}`: "Nil must be one of",
+		`{"Bot": "Grandmaster"}`: "Unknown bot level",
+		`{"Jokers": true}`: "unknown field",
+		`[]`: "Bad Spades options",
+	} {
+		_, err := ParseConfig(data)
+		assert.ErrorContains(t, err, msg, data)
+	}
+	config, err := ParseConfig(`{"Target": 300}`)
+	assert.NilError(t, err)
+	assert.Equal(t, config, Config{Target: 300, Nil: "Nil", Bot: "Medium"})
+	game := CreateGame()
+	for i := 0; i < 4; i++ {
+		game.AddPlayer(server.Player{Name: "", Type: "Human", Joined: true})
+	}
+	assert.ErrorContains(t, game.Init(`{"Target": 5000}`), "Target must be")
+}
+
+func TestTargetDealsAgain(t *testing.T) {
+	game := CreateGame()
+	for i := 0; i < 4; i++ {
+		game.AddPlayer(server.Player{Name: "", Type: "Human", Joined: true})
+	}
+	assert.NilError(t, game.Init(`{"Target": 500, "Nil": "NoNil"}`))
+	g := game.(*Game)
+	var scores [2]spades.Score
+	for g.State.Played == 0 {
+		acts := g.State.CurrentActions()
+		assert.Assert(t, acts[0].Verb != spades.BidVerb || acts[0].Bid == 1)
+		if len(g.State.Hands[acts[0].Player]) == 1 && g.State.Trick[2] != spades.NO_CARD {
+			last := g.State.GameState.Clone()
+			last.TakeAction(acts[0])
+			scores = last.ScoreHand(scores, false)
+		}
+		g.takeAction(acts[0])
+	}
+	assert.Equal(t, g.State.Scores, scores)
+	assert.Equal(t, g.State.Winner, -1)
+	assert.Assert(t, !game.IsOver())
+	// Next dealer bids first, with the same rules
+	assert.Equal(t, g.State.Attacker, 1)
+	assert.Equal(t, g.Record.Dealer, 1)
+	assert.Assert(t, g.State.NoNil)
+	for _,h := range g.State.Hands {
+		assert.Equal(t, len(h), 13)
+	}
+	assert.Assert(t, g.State.PrevTrick[0] != spades.NO_CARD)
+	// Both hands can be replayed
+	var st GameState
+	data, err := game.Replay(0, 56, -1)
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal([]byte(data), &st))
+	assert.Assert(t, st.IsOver())
+	assert.Equal(t, st.Replay.Hands, 2)
+	data, err = game.Replay(1, 0, -1)
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal([]byte(data), &st))
+	assert.DeepEqual(t, st.Hands, g.State.Hands)
+	_, err = game.Replay(2, 0, -1)
+	assert.ErrorContains(t, err, "No hand")
+}
+
+// Next reply of type typ, failing on errors
+func readReply(t *testing.T, conn *websocket.Conn, typ string) server.Reply {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5*time.Second))
+	for {
+		var reply server.Reply
+		assert.NilError(t, conn.ReadJSON(&reply))
+		if reply.Type == typ {
+			return reply
+		}
+		assert.Assert(t, reply.Type != "Error", "waiting for %s: %s", typ, reply.Data)
+	}
+}
+
+// The waiting room through the real game, which has no state until the deal
+func TestWaitingRoomSocket(t *testing.T) {
+	server.CreateGameFunc = CreateGame
+	srv := httptest.NewServer(http.HandlerFunc(server.Socket))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	dial := func () *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		assert.NilError(t, err)
+		t.Cleanup(func () { conn.Close() })
+		return conn
+	}
+	a, b, c := dial(), dial(), dial()
+	assert.NilError(t, a.WriteJSON(server.Request{Type: "New", Name: "A", Types: []string{"Human", "Human", "Easy", "Easy"}}))
+	var key int
+	json.Unmarshal([]byte(readReply(t, a, "New").Data), &key)
+	readReply(t, a, "Room")
+	assert.NilError(t, c.WriteJSON(server.Request{Type: "Spectate", Game: key, Name: "C"}))
+	readReply(t, c, "Room")
+	assert.NilError(t, a.WriteJSON(server.Request{Type: "Action", Game: key, Data: "{}"}))
+	readReply(t, a, "Error")
+	assert.NilError(t, b.WriteJSON(server.Request{Type: "Join", Game: key, Name: "B"}))
+	readReply(t, b, "Room")
+	assert.NilError(t, a.WriteJSON(server.Request{Type: "Start"}))
+	readReply(t, a, "Update")
+	readReply(t, b, "Update")
+	readReply(t, c, "Update")
+}
+
@@ -0,0 +1,470 @@
+package spades
+
+import (
+	"math/bits"
+)
+
+// Double-dummy solver
+// Every hand is known and everyone plays perfectly
+// Alpha-beta over cards with a transposition table at trick boundaries
+// Cards in a run with nothing outstanding in between are searched once
+// Table positions only keep the relative ranks of the cards still out
+// Quick tricks and top spades bound each position before it's searched
+
+// Who holds each card still out, two bits a card in order,
+// then the number out in each suit and the leader
+type ddKey [2]uint64
+
+// Small, the table holds millions
+type ddBounds struct {
+	lower int8
+	upper int8
+}
+
+type ddSolver struct {
+	hands [4]uint64
+	trick Trick
+	leader int
+	n int
+	table map[ddKey]ddBounds
+	// Positions searched, and the most allowed or 0 for no limit
+	nodes int
+	limit int
+}
+
+// Most positions kept in the transposition table, about 150MB when full
+// Full deals need up to a few million
+var MaxTableSize = 1 << 22
+
+// Positions a par search may visit before giving up, about half a minute
+// Full deals have needed at most about 60 million
+var ParNodes = 100000000
+
+func suitMask(suit int) uint64 {
+	return uint64(0x1fff) << (13*suit)
+}
+
+func newSolver(state *GameState) *ddSolver {
+	dd := &ddSolver{
+		trick: state.Trick,
+		leader: state.Attacker,
+		table: make(map[ddKey]ddBounds),
+	}
+	for i,h := range state.Hands {
+		for _,c := range h {
+			if c < 0 {
+				panic("unknown card in double dummy")
+			}
+			dd.hands[i] |= uint64(1) << c
+		}
+	}
+	for dd.n < 4 && dd.trick[dd.n] != NO_CARD {
+		dd.n++
+	}
+	return dd
+}
+
+// Tricks left including the one in progress
+func (dd *ddSolver) tricksLeft() int {
+	n := 0
+	for _,h := range dd.hands {
+		if bits.OnesCount64(h) > n {
+			n = bits.OnesCount64(h)
+		}
+	}
+	return n
+}
+
+// Positions that differ only in which cards were played are the same
+func (dd *ddSolver) key() ddKey {
+	var key ddKey
+	n := 0
+	put := func (v uint64, size int) {
+		key[n/64] |= v << (n%64)
+		if n%64 + size > 64 {
+			key[n/64+1] |= v >> (64 - n%64)
+		}
+		n += size
+	}
+	out := dd.hands[0] | dd.hands[1] | dd.hands[2] | dd.hands[3]
+	for rest := out; rest != 0; rest &= rest-1 {
+		c := bits.TrailingZeros64(rest)
+		owner := dd.hands[1]>>c & 1 | 2*(dd.hands[2]>>c & 1) | 3*(dd.hands[3]>>c & 1)
+		put(owner, 2)
+	}
+	for suit := 0; suit < 4; suit++ {
+		put(uint64(bits.OnesCount64(out & suitMask(suit))), 4)
+	}
+	put(uint64(dd.leader), 2)
+	return key
+}
+
+func (dd *ddSolver) player() int {
+	return (dd.leader + dd.n) % 4
+}
+
+// Legal cards for the player on turn, one per equivalent run, and how many
+// Fixed size so searching doesn't allocate
+func (dd *ddSolver) moves() ([13]Card, int) {
+	p := dd.player()
+	hand := dd.hands[p]
+	if dd.n > 0 {
+		follow := hand & suitMask(dd.trick[0].Suit())
+		if follow != 0 {
+			hand = follow
+		}
+	}
+	// Cards that split a run, including ones on the table
+	others := uint64(0)
+	for i := 0; i < 4; i++ {
+		if i != p {
+			others |= dd.hands[i]
+		}
+	}
+	for i := 0; i < dd.n; i++ {
+		others |= uint64(1) << dd.trick[i]
+	}
+	// Card winning the trick so far
+	w := 0
+	for i := 1; i < dd.n; i++ {
+		if dd.trick[i].Beats(dd.trick[w], dd.trick[0].Suit()) {
+			w = i
+		}
+	}
+	var moves [13]Card
+	var scores [13]int
+	n := 0
+	for suit := 0; suit < 4; suit++ {
+		if hand & suitMask(suit) == 0 {
+			continue
+		}
+		inRun := false
+		for r := 12; r >= 0; r-- {
+			c := suit*13 + r
+			bit := uint64(1) << c
+			if hand & bit != 0 {
+				if !inRun {
+					moves[n] = Card(c)
+					scores[n] = dd.order(p, Card(c), others, w)
+					n++
+				}
+				inRun = true
+			} else if others & bit != 0 {
+				inRun = false
+			}
+		}
+	}
+	// Insertion sort, best first
+	for i := 1; i < n; i++ {
+		for j := i; j > 0 && scores[j] > scores[j-1]; j-- {
+			moves[j], moves[j-1] = moves[j-1], moves[j]
+			scores[j], scores[j-1] = scores[j-1], scores[j]
+		}
+	}
+	return moves, n
+}
+
+// Move ordering: sure winners, then cheap winners, then low cards
+// w is the place in the trick of the card winning so far
+func (dd *ddSolver) order(p int, c Card, others uint64, w int) int {
+	higher := others & suitMask(c.Suit()) &^ (uint64(1) << (c+1) - 1)
+	if dd.n == 0 {
+		if higher == 0 {
+			return 100 + c.Rank()
+		}
+		return 50 - c.Rank()
+	}
+	partner := (w + dd.leader) % 4 == (p + 2) % 4
+	beats := c.Beats(dd.trick[w], dd.trick[0].Suit())
+	// Last to play: win cheaply or throw low
+	if dd.n == 3 {
+		if beats && !partner {
+			return 100 - c.Rank()
+		}
+		return 50 - c.Rank() - 20*btoi(c.Suit() == SUIT_SPADES)
+	}
+	if beats && !partner {
+		return 80 + c.Rank()
+	}
+	return 50 - c.Rank() - 20*btoi(c.Suit() == SUIT_SPADES)
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (dd *ddSolver) trickWinner() int {
+	w := 0
+	for i := 1; i < 4; i++ {
+		if dd.trick[i].Beats(dd.trick[w], dd.trick[0].Suit()) {
+			w = i
+		}
+	}
+	return (w + dd.leader) % 4
+}
+
+// Top spades one player holds, each wins a trick whenever it's played
+func (dd *ddSolver) sureSpades(side int) int {
+	counts := [4]int{}
+	for c := 13*SUIT_SPADES + 12; c >= 13*SUIT_SPADES; c-- {
+		bit := uint64(1) << c
+		owner := -1
+		for i := 0; i < 4; i++ {
+			if dd.hands[i] & bit != 0 {
+				owner = i
+			}
+		}
+		if owner == -1 {
+			continue
+		}
+		if owner%2 != side {
+			break
+		}
+		counts[owner]++
+	}
+	return max(counts[side], counts[side+2])
+}
+
+// Winners player could cash one after another from the lead
+// before an opponent can ruff, and the suits with at least one
+func (dd *ddSolver) quickTricks(player int) (int, [4]bool) {
+	hand := dd.hands[player]
+	out := dd.hands[0] | dd.hands[1] | dd.hands[2] | dd.hands[3]
+	n := 0
+	var cash [4]bool
+	for suit := 0; suit < 4; suit++ {
+		k := 0
+		for c := 13*suit + 12; c >= 13*suit; c-- {
+			bit := uint64(1) << c
+			if hand & bit != 0 {
+				k++
+			} else if out & bit != 0 {
+				break
+			}
+		}
+		if suit != SUIT_SPADES {
+			for _,opp := range []int{(player+1)%4, (player+3)%4} {
+				follow := bits.OnesCount64(dd.hands[opp] & suitMask(suit))
+				if follow < k && dd.hands[opp] & suitMask(SUIT_SPADES) != 0 {
+					k = follow
+				}
+			}
+		}
+		n += k
+		cash[suit] = k > 0
+	}
+	return n, cash
+}
+
+// Bounds on tricks for players 0 and 2 at the start of a trick
+// The leader's side cashes the leader's winners,
+// or leads to partner's and cashes those
+func (dd *ddSolver) bounds(left int) ddBounds {
+	sure := [2]int{dd.sureSpades(0), dd.sureSpades(1)}
+	side := dd.leader%2
+	qt, _ := dd.quickTricks(dd.leader)
+	partner := (dd.leader+2)%4
+	pqt, cash := dd.quickTricks(partner)
+	for suit := 0; suit < 4; suit++ {
+		if cash[suit] && dd.hands[dd.leader] & suitMask(suit) != 0 {
+			qt = max(qt, pqt)
+		}
+	}
+	sure[side] = max(sure[side], qt)
+	return ddBounds{int8(min(sure[0], left)), int8(max(left-sure[1], 0))}
+}
+
+// Ran out of positions, every result since is meaningless
+func (dd *ddSolver) aborted() bool {
+	return dd.limit > 0 && dd.nodes > dd.limit
+}
+
+// Tricks for players 0 and 2 from here
+func (dd *ddSolver) search(alpha int, beta int) int {
+	dd.nodes++
+	if dd.aborted() {
+		return alpha
+	}
+	if dd.n != 0 {
+		return dd.searchMoves(alpha, beta)
+	}
+	left := bits.OnesCount64(dd.hands[dd.leader])
+	if left == 0 {
+		return 0
+	}
+	key := dd.key()
+	b, ok := dd.table[key]
+	if !ok {
+		b = dd.bounds(left)
+	}
+
+	lower, upper := int(b.lower), int(b.upper)
+	if lower >= beta || lower == upper {
+		return lower
+	}
+	if upper <= alpha {
+		return upper
+	}
+	alpha = max(alpha, lower)
+	beta = min(beta, upper)
+	v := dd.searchMoves(alpha, beta)
+	if v <= alpha {
+		b.upper = int8(min(upper, v))
+	} else if v >= beta {
+		b.lower = int8(max(lower, v))
+	} else {
+		b.lower = int8(v)
+		b.upper = int8(v)
+	}
+	if dd.aborted() {
+		return v
+	}
+	if ok || len(dd.table) < MaxTableSize {
+		dd.table[key] = b
+	}
+	return v
+}
+
+func (dd *ddSolver) searchMoves(alpha int, beta int) int {
+	p := dd.player()
+	maximize := p%2 == 0
+	best := -1
+	if !maximize {
+		best = 14
+	}
+	moves, n := dd.moves()
+	for _,c := range moves[:n] {
+		v := dd.play(p, c, alpha, beta)
+		if maximize {
+			best = max(best, v)
+			alpha = max(alpha, best)
+		} else {
+			best = min(best, v)
+			beta = min(beta, best)
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return best
+}
+
+func (dd *ddSolver) play(p int, c Card, alpha int, beta int) int {
+	bit := uint64(1) << c
+	dd.hands[p] &^= bit
+	dd.trick[dd.n] = c
+	dd.n++
+	var v int
+	if dd.n == 4 {
+		leader := dd.leader
+		trick := dd.trick
+		won := 0
+		dd.leader = dd.trickWinner()
+		if dd.leader%2 == 0 {
+			won = 1
+		}
+		dd.n = 0
+		dd.trick = InitTrick()
+		v = dd.search(alpha-won, beta-won) + won
+		dd.trick = trick
+		dd.leader = leader
+		dd.n = 4
+	} else {
+		v = dd.search(alpha, beta)
+	}
+	dd.n--
+	dd.trick[dd.n] = NO_CARD
+	dd.hands[p] |= bit
+	return v
+}
+
+// Zero-window searches narrowing in on the exact value
+func (dd *ddSolver) solve() int {
+	lo := 0
+	hi := dd.tricksLeft()
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if dd.search(mid-1, mid) >= mid {
+			lo = mid
+		} else {
+			hi = mid-1
+		}
+	}
+	return lo
+}
+
+// Tricks each side takes from here on with perfect play
+// Index 0 is players 0 and 2, index 1 is players 1 and 3
+// All hands must be known
+func (state *GameState) DoubleDummy() [2]int {
+	dd := newSolver(state)
+	t := dd.solve()
+	return [2]int{t, dd.tricksLeft() - t}
+}
+
+// Tricks the player's side takes from here on for each legal card
+func (state *GameState) DoubleDummyCards(player int) ([]Card, []int) {
+	acts := state.PlayerActions(player)
+	cards := make([]Card, 0)
+	tricks := make([]int, 0)
+	dd := newSolver(state)
+	left := dd.tricksLeft()
+	for _,a := range acts {
+		if a.Verb != PlayVerb {
+			continue
+		}
+		bit := uint64(1) << a.Card
+		dd.hands[player] &^= bit
+		dd.trick[dd.n] = a.Card
+		dd.n++
+		won := 0
+		leader := dd.leader
+		trick := dd.trick
+		if dd.n == 4 {
+			dd.leader = dd.trickWinner()
+			if dd.leader%2 == 0 {
+				won = 1
+			}
+			dd.n = 0
+			dd.trick = InitTrick()
+		}
+		t := dd.solve() + won
+		if dd.n == 0 {
+			dd.trick = trick
+			dd.leader = leader
+			dd.n = 4
+		}
+		dd.n--
+		dd.trick[dd.n] = NO_CARD
+		dd.hands[player] |= bit
+		if player%2 == 1 {
+			t = left - t
+		}
+		cards = append(cards, a.Card)
+		tricks = append(tricks, t)
+	}
+	return cards, tricks
+}
+
+// Par result of a fresh deal, as tricks for each side
+// False if the search gave up after ParNodes positions
+func ParResult(hands [4][]Card, leader int) ([2]int, bool) {
+	st := &GameState{
+		Attacker: leader,
+		Trick: InitTrick(),
+		PrevTrick: InitTrick(),
+	}
+	for i,h := range hands {
+		st.Hands[i] = append(make([]Card, 0), h...)
+	}
+	dd := newSolver(st)
+	dd.limit = ParNodes
+	t := dd.solve()
+	if dd.aborted() {
+		return [2]int{}, false
+	}
+	return [2]int{t, dd.tricksLeft() - t}, true
+}
@@ -2,7 +2,10 @@ package spades
 
 import (
 	assert "gotest.tools/v3/assert"
+	"bytes"
 	"fmt"
+	"math/rand/v2"
+	"strings"
 	"testing"
 )
 
@@ -95,7 +98,7 @@ func TestGameAIToCompletion(t *testing.T) {
 	var act Action
 	for i := 0; i < 4; i++ {
 		b := state.DecideBids(i, 300)
-		act := Action{Verb: BidVerb, Player: i, Bid: b}
+		act := Action{Verb: BidVerb, Player: i, Bid: b.Bid}
 		state.TakeAction(act)
 	}
 	for !state.IsOver() && count < 1000 {
@@ -118,3 +121,442 @@ func TestGameAIToCompletion(t *testing.T) {
 	fmt.Println(state.Tricks)
 	assert.Assert(t, count != 1000, "Game never finished")
 }
+
+func TestContractMode(t *testing.T) {
+	state := InitGameState()
+	state.Bids = [4]int{3,4,2,4}
+	assert.Equal(t, state.ContractMode(0), MakeContract)
+	// Players 0 and 2 make their 5 with 6 tricks to go
+	state.Tricks = [4]int{3,1,2,1}
+	for i := 0; i < 4; i++ {
+		state.Hands[i] = state.Hands[i][:6]
+	}
+	assert.Equal(t, state.TricksNeeded(0), 0)
+	assert.Equal(t, state.TricksNeeded(1), 6)
+	assert.Equal(t, state.ContractMode(0), SetContract)
+	assert.Equal(t, state.ContractMode(1), MakeContract)
+	// Opponents have slack
+	state.Tricks = [4]int{3,4,2,4}
+	for i := 0; i < 4; i++ {
+		state.Hands[i] = state.Hands[i][:0]
+	}
+	assert.Equal(t, state.ContractMode(0), AvoidBags)
+}
+
+func TestContractModeSetOrNil(t *testing.T) {
+	state := InitGameState()
+	state.Bids = [4]int{3,4,2,4}
+	// Opponents need 7 with 6 to go, already set
+	state.Tricks = [4]int{4,0,3,0}
+	for i := 0; i < 4; i++ {
+		state.Hands[i] = state.Hands[i][:6]
+	}
+	assert.Equal(t, state.TricksNeeded(1), 8)
+	assert.Equal(t, state.ContractMode(0), AvoidBags)
+	// A nil bidder ducks and its tricks don't help its partner
+	state.Bids = [4]int{0,4,5,4}
+	state.Tricks = [4]int{1,0,3,0}
+	assert.Equal(t, state.TricksNeeded(0), 2)
+	assert.Equal(t, state.TricksNeeded(2), 2)
+	assert.Equal(t, state.ContractMode(0), AvoidBags)
+	assert.Equal(t, state.ContractMode(2), MakeContract)
+}
+
+func TestAvoidBagsDucks(t *testing.T) {
+	state := InitGameState()
+	state.Bids = [4]int{1,1,1,1}
+	state.Tricks = [4]int{1,1,1,1}
+	// Ace and two of hearts against a king of hearts lead
+	state.Hands[0] = []Card{CardFromRankSuit(12, 2), CardFromRankSuit(0, 2), CardFromRankSuit(5, 2)}
+	state.Hands[1] = []Card{CardFromRankSuit(1, 2), CardFromRankSuit(2, 2)}
+	state.Hands[2] = []Card{CardFromRankSuit(3, 2), CardFromRankSuit(4, 2)}
+	state.Hands[3] = []Card{CardFromRankSuit(11, 2), CardFromRankSuit(6, 2), CardFromRankSuit(7, 2)}
+	state.Attacker = 3
+	state.TakeAction(Action{Verb: PlayVerb, Player: 3, Card: CardFromRankSuit(11, 2)})
+	assert.Equal(t, state.ContractMode(0), AvoidBags)
+	c := state.ChooseLowValueCard(0)
+	assert.Equal(t, c, CardFromRankSuit(5, 2))
+	act := state.DecidePlayNotFirst(10)
+	assert.Assert(t, act.Card != CardFromRankSuit(12, 2), "won the trick while avoiding bags")
+}
+
+func TestChooseLowestCardSpadesLast(t *testing.T) {
+	state := InitGameState()
+	state.Bids = [4]int{1,1,1,1}
+	state.Hands[0] = []Card{CardFromRankSuit(0, SUIT_SPADES), CardFromRankSuit(5, 2), CardFromRankSuit(3, 0)}
+	assert.Equal(t, state.ChooseLowestCard(0), CardFromRankSuit(3, 0))
+	state.Hands[0] = state.Hands[0][:1]
+	assert.Equal(t, state.ChooseLowestCard(0), CardFromRankSuit(0, SUIT_SPADES))
+}
+
+func TestChooseWinningCardThreshold(t *testing.T) {
+	cards := []Card{Card(1), Card(2)}
+	wins := []int{4, 7}
+	assert.Equal(t, ChooseWinningCard(cards, wins, 10, 0.5), Card(2))
+	assert.Equal(t, ChooseWinningCard(cards, wins, 10, 0.3), Card(1))
+	assert.Equal(t, ChooseWinningCard(cards, wins, 10, 2.0), NO_CARD)
+}
+
+// Plain minimax over every legal card
+func bruteForceTricks(state *GameState) int {
+	if state.IsOver() {
+		return 0
+	}
+	p := state.Attacker
+	for i := 0; i < 4; i++ {
+		if state.Trick[i] == NO_CARD {
+			p = (state.Attacker + i) % 4
+			break
+		}
+	}
+	best := -1
+	for _,a := range state.PlayerActions(p) {
+		st := state.Clone()
+		st.TakeAction(a)
+		v := st.Tricks[0] + st.Tricks[2] - state.Tricks[0] - state.Tricks[2] + bruteForceTricks(st)
+		if best == -1 || (p%2 == 0 && v > best) || (p%2 == 1 && v < best) {
+			best = v
+		}
+	}
+	return best
+}
+
+func playRandomly(state *GameState, nCards int) {
+	state.Bids = [4]int{3,3,3,3}
+	for i := 0; i < nCards; i++ {
+		acts := state.CurrentActions()
+		state.TakeAction(acts[rand.IntN(len(acts))])
+	}
+}
+
+func TestDoubleDummyMatchesBruteForce(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		state := InitGameState()
+		// Four tricks left with part of a trick played
+		playRandomly(state, 37+i%4)
+		dd := state.DoubleDummy()
+		bf := bruteForceTricks(state)
+		assert.Equal(t, dd[0], bf)
+		assert.Equal(t, dd[0]+dd[1], state.TricksLeft())
+	}
+}
+
+func TestDoubleDummyCards(t *testing.T) {
+	state := InitGameState()
+	playRandomly(state, 40)
+	dd := state.DoubleDummy()
+	cards, tricks := state.DoubleDummyCards(state.Attacker)
+	assert.Equal(t, len(cards), len(state.Hands[state.Attacker]))
+	best := 0
+	for _,n := range tricks {
+		best = max(best, n)
+	}
+	assert.Equal(t, best, dd[state.Attacker%2])
+}
+
+func TestParResult(t *testing.T) {
+	// Each player holds a whole suit, spades win everything
+	hands := [4][]Card{}
+	for i := 0; i < 4; i++ {
+		for r := 0; r < 13; r++ {
+			hands[i] = append(hands[i], CardFromRankSuit(r, i))
+		}
+	}
+	for leader := 0; leader < 2; leader++ {
+		par, ok := ParResult(hands, leader)
+		assert.Assert(t, ok)
+		assert.Equal(t, par, [2]int{0, 13})
+	}
+	// Hard deals give up instead of running on
+	defer func (n int) { ParNodes = n }(ParNodes)
+	ParNodes = 1000
+	_, ok := ParResult(InitGameStateSeed(0).Hands, 0)
+	assert.Assert(t, !ok)
+}
+
+// Typical full deals finish well inside the budget
+func TestParRandomDeals(t *testing.T) {
+	for seed := int64(0); seed < 3; seed++ {
+		state := InitGameStateSeed(seed)
+		par, ok := ParResult(state.Hands, 0)
+		assert.Assert(t, ok, "gave up on seed %d", seed)
+		assert.Equal(t, par[0]+par[1], 13)
+	}
+}
+
+func TestSampleHandsConsistent(t *testing.T) {
+	state := InitGameState()
+	playRandomly(state, 21)
+	for i := 0; i < 100; i++ {
+		st := state.Clone()
+		assert.Assert(t, st.SampleHands(1))
+		seen := make(map[Card]bool)
+		for p,h := range st.Hands {
+			assert.Equal(t, len(h), len(state.Hands[p]))
+			for _,c := range h {
+				assert.Assert(t, !seen[c], "card %v dealt twice", c)
+				assert.Assert(t, !state.Absent[1][p][c], "card %v dealt to %v against constraints", c, p)
+				seen[c] = true
+			}
+		}
+		assert.DeepEqual(t, st.Hands[1], state.Hands[1])
+	}
+}
+
+func TestDecideBidsDistribution(t *testing.T) {
+	state := InitGameState()
+	est := state.DecideBids(0, 100)
+	assert.Assert(t, est.Sims > 0)
+	sum := 0.0
+	for _,p := range est.Dist {
+		sum += p
+	}
+	assert.Assert(t, sum > 0.999 && sum < 1.001, "distribution sums to %v", sum)
+	assert.Assert(t, est.Confidence >= 0.5, "confidence %v", est.Confidence)
+	assert.Assert(t, est.BidWithConfidence(0.9) <= est.Bid)
+}
+
+func TestSampleHandsTightConstraints(t *testing.T) {
+	state := InitGameState()
+	state.Absent[0] = [4][52]bool{}
+	// Player 1 can only hold clubs, player 2 only hearts and player 3 gets the rest
+	for c := 0; c < 52; c++ {
+		if Card(c).Suit() != 0 {
+			state.Absent[0][1][c] = true
+		}
+		if Card(c).Suit() != 2 {
+			state.Absent[0][2][c] = true
+		}
+		if Card(c).Suit() == 0 || Card(c).Suit() == 2 {
+			state.Absent[0][3][c] = true
+		}
+	}
+	state.Hands[0] = []Card{}
+	state.Hands[1] = make([]Card, 13)
+	state.Hands[2] = make([]Card, 13)
+	state.Hands[3] = make([]Card, 26)
+	for i := 0; i < 20; i++ {
+		st := state.Clone()
+		assert.Assert(t, st.SampleHands(0))
+		for _,c := range st.Hands[1] {
+			assert.Equal(t, c.Suit(), 0)
+		}
+		for _,c := range st.Hands[2] {
+			assert.Equal(t, c.Suit(), 2)
+		}
+	}
+	// One card too many for player 1
+	state.Hands[1] = make([]Card, 14)
+	state.Hands[3] = make([]Card, 25)
+	assert.Assert(t, !state.Clone().SampleHands(0))
+}
+
+func TestSampleHandsUniform(t *testing.T) {
+	state := InitGameState()
+	state.Absent[0] = [4][52]bool{}
+	// Three cards left, player 1 can't hold the first
+	state.Hands[0] = []Card{}
+	state.Hands[1] = []Card{Card(0)}
+	state.Hands[2] = []Card{Card(1)}
+	state.Hands[3] = []Card{Card(2)}
+	for c := 3; c < 52; c++ {
+		for j := 0; j < 4; j++ {
+			state.Absent[0][j][c] = true
+		}
+	}
+	state.Absent[0][1][0] = true
+	// Four deals fit, each should show up about a quarter of the time
+	counts := make(map[[3]Card]int)
+	for i := 0; i < 4000; i++ {
+		st := state.Clone()
+		assert.Assert(t, st.SampleHands(0))
+		counts[[3]Card{st.Hands[1][0], st.Hands[2][0], st.Hands[3][0]}]++
+	}
+	assert.Equal(t, len(counts), 4)
+	for deal,n := range counts {
+		assert.Assert(t, n > 800 && n < 1200, "deal %v drawn %v times", deal, n)
+	}
+}
+
+func TestSearchGameToCompletion(t *testing.T) {
+	state := InitGameState()
+	count := 0
+	for !state.IsOver() && count < 1000 {
+		acted := false
+		for p := 0; p < 4; p++ {
+			act, ok := state.FindBestAction(p, 6, 20)
+			if !ok {
+				continue
+			}
+			assert.Assert(t, Includes(state.PlayerActions(p), act), "illegal action %v", act.ToStr())
+			state.TakeAction(act)
+			acted = true
+			break
+		}
+		assert.Assert(t, acted, "nobody could act")
+		count++
+	}
+	assert.Assert(t, state.IsOver(), "game never finished")
+	assert.Equal(t, state.Tricks[0]+state.Tricks[1]+state.Tricks[2]+state.Tricks[3], 13)
+}
+
+func TestEvalContract(t *testing.T) {
+	state := InitGameState()
+	state.Bids = [4]int{3,2,1,2}
+	state.Tricks = [4]int{3,0,1,0}
+	for i := 0; i < 4; i++ {
+		state.Hands[i] = state.Hands[i][:9]
+	}
+	// Made 4 versus 0 of 4 with 9 to go
+	assert.Equal(t, state.ContractValue(0), 40.0)
+	assert.Equal(t, state.ContractValue(1), 0.0)
+	assert.Equal(t, state.Eval(0), 40.0)
+	assert.Equal(t, state.Eval(1), -40.0)
+	// Set with no tricks left
+	for i := 0; i < 4; i++ {
+		state.Hands[i] = state.Hands[i][:0]
+	}
+	state.Tricks = [4]int{5,1,6,1}
+	assert.Equal(t, state.ContractValue(1), -40.0)
+	assert.Equal(t, state.ContractValue(0), 47.0)
+}
+
+func TestLevelsPlayGame(t *testing.T) {
+	names := []string{"Easy", "Medium", "Hard", "Expert"}
+	levels := make([]*Level, 4)
+	for i,name := range names {
+		level, ok := LevelByName(name)
+		assert.Assert(t, ok)
+		// Quicker for testing
+		l := *level
+		l.BidBudget = min(l.BidBudget, 20)
+		l.PlayBudget = min(l.PlayBudget, 20)
+		levels[i] = &l
+	}
+	state := InitGameState()
+	rec := NewRecord(state, [4]string(names))
+	for count := 0; !state.IsOver(); count++ {
+		assert.Assert(t, count < 100, "game never finished")
+		for p := 0; p < 4; p++ {
+			st := state.Clone()
+			st.Mask(p)
+			act, ok := st.DecideAction(p, levels[p])
+			if !ok {
+				continue
+			}
+			assert.Assert(t, Includes(state.PlayerActions(p), act), "illegal action %v", act.ToStr())
+			rec.TakeAction(state, act)
+		}
+	}
+	final, err := rec.Final()
+	assert.NilError(t, err)
+	assert.Equal(t, final.Tricks, state.Tricks)
+	_, ok := LevelByName("Computer")
+	assert.Assert(t, ok)
+	_, ok = LevelByName("Grandmaster")
+	assert.Assert(t, !ok)
+}
+
+func TestLevelBidNil(t *testing.T) {
+	state := InitGameState()
+	level := Levels["Medium"]
+	// No bid reaches the confidence but one trick is likely
+	est := BidEstimate{Dist: [14]float64{0.35, 0.35, 0.3}}
+	assert.Equal(t, est.BidWithConfidence(level.BidConfidence), 0)
+	assert.Equal(t, state.LevelBid(est, level), 1)
+	// Sure of no tricks, so nil on purpose
+	est = BidEstimate{Dist: [14]float64{0.9, 0.1}}
+	assert.Equal(t, state.LevelBid(est, level), 0)
+	state.NoNil = true
+	assert.Equal(t, state.LevelBid(est, level), 1)
+}
+
+func TestAnalyze(t *testing.T) {
+	// Bidding 13 on a random hand almost never makes
+	state := InitGameState()
+	acts := []Action{{Verb: BidVerb, Player: 0, Card: NO_CARD, Bid: 13}}
+	annots, err := Analyze(state, acts, 50, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(annots), 1)
+	assert.Assert(t, annots[0].Blunder, annots[0].ToStr())
+	assert.Assert(t, annots[0].Best.Bid < 13)
+	// End of a hand is scored double dummy
+	state = InitGameState()
+	playRandomly(state, 44)
+	p := state.ToPlay()
+	acts = []Action{{Verb: PlayVerb, Player: p, Card: state.Hands[p][0]}}
+	annots, err = Analyze(state, acts, 20, 10)
+	assert.NilError(t, err)
+	assert.Assert(t, annots[0].BestScore >= annots[0].Score)
+	// Not in hand
+	acts = []Action{{Verb: PlayVerb, Player: p, Card: UNK_CARD}}
+	_, err = Analyze(state, acts, 20, 10)
+	assert.Assert(t, err != nil)
+}
+
+func TestRecordPBN(t *testing.T) {
+	hands := InitGameState().Hands
+	state := DealGameState(hands, 2)
+	rec := NewRecord(state, [4]string{"Alice", "Easy", "Bob \"B\"", "Hard"})
+	rec.Seed = 12345
+	for !state.IsOver() {
+		acts := state.CurrentActions()
+		rec.TakeAction(state, acts[rand.IntN(len(acts))])
+	}
+	var buf bytes.Buffer
+	assert.NilError(t, rec.WritePBN(&buf))
+	pbn := buf.String()
+	loaded, err := ParsePBN(&buf)
+	assert.NilError(t, err, pbn)
+	assert.DeepEqual(t, loaded.Players, rec.Players)
+	assert.Equal(t, loaded.Seed, rec.Seed)
+	assert.Equal(t, loaded.Dealer, 2)
+	assert.DeepEqual(t, loaded.Actions, rec.Actions)
+	final, err := loaded.Final()
+	assert.NilError(t, err)
+	assert.Equal(t, final.Tricks, state.Tricks)
+	tricks := loaded.Tricks()
+	assert.Equal(t, len(tricks), 13)
+	assert.Equal(t, tricks[0].Leader, 2)
+	// A wrong trick winner is caught
+	seat := seatNames[tricks[0].Winner]
+	other := seatNames[(tricks[0].Winner+1)%4]
+	bad := strings.Replace(pbn, "-> "+seat+"\n", "-> "+other+"\n", 1)
+	_, err = ParsePBN(strings.NewReader(bad))
+	assert.Assert(t, err != nil)
+}
+
+func TestInitGameStateSeed(t *testing.T) {
+	a := InitGameStateSeed(12345)
+	assert.DeepEqual(t, a, InitGameStateSeed(12345))
+	assert.Assert(t, fmt.Sprint(a.Hands) != fmt.Sprint(InitGameStateSeed(54321).Hands))
+	for _,h := range a.Hands {
+		assert.Equal(t, len(h), 13)
+	}
+}
+
+func TestScoreHand(t *testing.T) {
+	state := InitGameState()
+	// North makes nil, South and North make 4 with 2 bags, East and West go set
+	state.Bids = [4]int{0,3,4,5}
+	state.Tricks = [4]int{0,4,6,3}
+	scores := state.ScoreHand([2]Score{{Points: 90, Bags: 8}, {}}, true)
+	assert.Equal(t, scores[0], Score{Points: 90 + NilBonus + 42 - BagPenalty, Bags: 0})
+	assert.Equal(t, scores[1], Score{Points: -80, Bags: 0})
+	// Without nil the zero is only part of the contract
+	scores = state.ScoreHand([2]Score{}, false)
+	assert.Equal(t, scores[0], Score{Points: 42, Bags: 2})
+	assert.Equal(t, Winner(scores, 40), 0)
+	assert.Equal(t, Winner(scores, 50), -1)
+}
+
+func TestNoNil(t *testing.T) {
+	state := InitGameState()
+	state.NoNil = true
+	acts := state.PlayerActions(0)
+	assert.Equal(t, len(acts), 13)
+	assert.Equal(t, acts[0].Bid, 1)
+	act, ok := state.Clone().DecideAction(0, Levels["Easy"])
+	assert.Assert(t, ok)
+	assert.Assert(t, act.Bid >= 1)
+}
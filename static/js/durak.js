@@ -26,44 +26,26 @@ window.addEventListener('load', () => {
 	let playerId = -1;
 	let myActions = [];
 	let conn = null;
-
-	// Update the list of games
-	function updateList(lst) {
-		const existing = $$('#games-select option');
-		const myGameName = `Game ${gameId}`;
-		// Remove invalid existing entries
-		for (let i=0; i<existing.length; i++) {
-			let found = false;
-			for (let j=0; j<lst.length; j++) {
-				const name = `Game ${lst[j]}`;
-				if (name == existing[i].innerText) {
-					found = true;
-					break;
-				}
-			}
-			if (!found || existing[i].innerText == myGameName) {
-				existing[i].parentNode.removeChild(existing[i]);
-			}
+	let seed = 0;
+
+	// Describe a lobby entry, e.g. Game 3 (Spades, Waiting): Anna, Medium, 2 open
+	function describeListing(lst) {
+		const names = lst.Players.filter(p => p.Joined).map(p => p.Type == 'Human' ? p.Name : p.Type);
+		let desc = `Game ${lst.Key} (${lst.Game}, ${lst.Status}): ${names.join(', ')}`;
+		if (lst.OpenSeats.length > 0) {
+			desc += `, ${lst.OpenSeats.length} open`;
 		}
-		// Add new entries
-		for (let i=0; i<lst.length; i++) {
-			const name = `Game ${lst[i]}`;
-			if (name == myGameName) {
-				continue;
-			}
-			let found = false;
-			for (let i=0; i<existing.length; i++) {
-				if (existing[i].innerText == name) {
-					found = true;
-					break;
-				}
-			}
-			if (!found) {
-				const opt = document.createElement('option');
-				opt.innerText = name;
-				$('#games-select').appendChild(opt);
-			}
+		if (lst.Spectators > 0) {
+			desc += `, ${lst.Spectators} watching`;
 		}
+		return desc;
+	}
+
+	// Update the lobby, games with open seats can be joined and any can be watched
+	function updateList(lobby) {
+		const open = lobby.filter(lst => lst.OpenSeats.length > 0 && lst.Key != gameId);
+		fillSelect($('#games-select'), open.map(lst => lst.Key), open.map(describeListing));
+		fillSelect($('#live-select'), lobby.map(lst => lst.Key), lobby.map(describeListing));
 	}
 
 	const canvas = $('#board');
@@ -89,6 +71,8 @@ window.addEventListener('load', () => {
 	function updateBoard(data) {
 		playerId = data.Player;
 		myActions = data.Actions;
+		// Replays and open tables may show every hand
+		const revealed = (data.Replay && data.Replay.Seat == -1) || (data.Spectator && data.Spectator.Open);
 		const nh = data.Hands.length;
 		let lrtbs = ['bottom', 'top'];
 		let offsets = [0, 0];
@@ -139,7 +123,7 @@ window.addEventListener('load', () => {
 				// For switching visibility later
 				card.cardIdx = cardIdx;
 				// Don't show even known enemy cards
-				if (i != 0 && !$('#show-known').checked) {
+				if (i != 0 && !$('#show-known').checked && !revealed) {
 					card.visible = false;
 				}
 				hand.cards.push(card);
@@ -225,6 +209,10 @@ window.addEventListener('load', () => {
 			}
 		}
 
+		if (data.Replay) {
+			board.message = `Move ${data.Replay.Move} of ${data.Replay.Moves}`;
+		}
+
 		// Display deck and trump
 		const cardIdx = data.Trump;
 		const suit = suits[Math.floor(cardIdx/9)];
@@ -239,13 +227,30 @@ window.addEventListener('load', () => {
 		board.draw();
 	}
 
-	conn = new WebSocket(`ws://${location.host}/ws`);
+	const sessionKey = 'durak-session';
+
+	function connect() {
+		conn = new WebSocket(`ws://${location.host}/ws`);
+		conn.onmessage = onMessage;
+		// Follow the lobby and reclaim our seat after a dropped connection or a reload
+		conn.onopen = () => {
+			conn.send(JSON.stringify({'Type': 'Subscribe'}));
+			const session = JSON.parse(localStorage.getItem(sessionKey));
+			if (session) {
+				conn.send(JSON.stringify({'Type': 'Reconnect', 'Game': session.Game, 'Data': session.Token}));
+			} else if (invite) {
+				sendJoin(invite.Game, invite.Code);
+			}
+			invite = null;
+		};
+		conn.onclose = () => setTimeout(connect, 1000);
+	}
 
 	/*conn.onopen = () => {
 		conn.send(JSON.stringify({'Type': 'List'}));
 	}*/
 
-	conn.onmessage = e => {
+	function onMessage(e) {
 		const json = JSON.parse(e.data);
 		const data = json.Data ? JSON.parse(json.Data) : null;
 		switch (json.Type) {
@@ -258,41 +263,220 @@ window.addEventListener('load', () => {
 			case 'New':
 				gameId = data;
 				break;
+			case 'Session':
+				localStorage.setItem(sessionKey, JSON.stringify(data));
+				break;
+			case 'Expired':
+				localStorage.removeItem(sessionKey);
+				break;
 			case 'Join':
 				gameId = data;
 				break;
+			case 'Room':
+				updateRoom(data);
+				break;
+			case 'Invite': {
+				const link = `${location.origin}${location.pathname}?game=${data.Game}&code=${encodeURIComponent(data.Code)}`;
+				$('#chat').value += `Private game ${data.Game}, invite others with ${link}\n`;
+				$('#chat').scrollTop = $('#chat').scrollHeight;
+				break;
+			}
+			case 'Spectate':
+				gameId = data;
+				break;
+			case 'Pace':
+				$('#chat').value += data.Paused ? 'Bots paused\n' : 'Bots playing\n';
+				$('#chat').scrollTop = $('#chat').scrollHeight;
+				break;
 			case 'Update':
+				$('#room').innerHTML = '';
+				$('#deal').disabled = true;
+				// Report the seed so a game can be dealt again
+				if (data.Seed && data.Seed != seed) {
+					seed = data.Seed;
+					$('#chat').value += `Dealt from seed ${seed}\n`;
+				}
+				updateBoard(data);
+				break;
+			case 'Replay':
+				replayMove = data.Replay.Move;
+				replayMoves = data.Replay.Moves;
 				updateBoard(data);
 				break;
+			case 'Finished':
+				updateFinished(data);
+				break;
+			case 'Hint':
+				$('#chat').value += `Hint: ${data.Reason}\n`;
+				$('#chat').scrollTop = $('#chat').scrollHeight;
+				break;
 			case 'Chat':
-				$('#chat').value += `${data.Name}: ${data.Message}\n`;
+				$('#chat').value += data.Spectator ? `${data.Name} (spectator): ${data.Message}\n` : `${data.Name}: ${data.Message}\n`;
 				$('#chat').scrollTop = $('#chat').scrollHeight;
 				break;
 		}
 	}
 
+	function sendJoin(key, code) {
+		const req = {'Type': 'Join', 'Game': key, 'Name': $('#name').value, 'Code': code};
+		if ($('#join-seat').value != '') {
+			req.Seat = parseInt($('#join-seat').value);
+		}
+		conn.send(JSON.stringify(req));
+	}
+
+	// Seats before the deal, we can move to any other and the host deals
+	function updateRoom(room) {
+		const div = $('#room');
+		div.innerHTML = '';
+		room.Seats.forEach((seat, i) => {
+			const sdiv = document.createElement('div');
+			let desc = seat.Type != 'Human' ? seat.Type : seat.Joined ? seat.Name : 'Open';
+			if (i == room.Host) {
+				desc += ' (host)';
+			}
+			if (i == room.You) {
+				desc += ' (you)';
+			}
+			sdiv.innerText = `Seat ${i+1}: ${desc} `;
+			if (room.You != -1 && i != room.You) {
+				const btn = document.createElement('button');
+				btn.innerText = 'Sit here';
+				btn.addEventListener('click', () => {
+					conn.send(JSON.stringify({'Type': 'Seat', 'Data': JSON.stringify(i)}));
+				});
+				sdiv.appendChild(btn);
+			}
+			div.appendChild(sdiv);
+		});
+		$('#deal').disabled = room.You == -1 || room.You != room.Host;
+	}
+
+	$('#deal').addEventListener('click', () => {
+		conn.send(JSON.stringify({'Type': 'Start'}));
+	});
+
+	// Invite links join as soon as we're connected
+	const params = new URLSearchParams(location.search);
+	let invite = params.has('game') ? {'Game': parseInt(params.get('game')), 'Code': params.get('code') ?? ''} : null;
+
+	connect();
+
 	setInterval(() => {
 		if (conn.readyState === WebSocket.OPEN) {
-			conn.send(JSON.stringify({'Type': 'List'}));
+			conn.send(JSON.stringify({'Type': 'Finished'}));
 		}
 	}, 1000);
 
 	$('#start').addEventListener('click', () => {
 		makeDummyHand();
-		conn.send(JSON.stringify({'Type': 'New', 'Types': players, 'Name': $('#name').value}));
+		const code = $('#password').value;
+		const priv = $('#private').checked || code != '';
+		// Rule options, checked by the server
+		const config = {
+			'Rules': $('#rules').value,
+			'DeckSize': parseInt($('#deck-size').value),
+			'Bot': $('#bot').value,
+			'Budget': parseInt($('#budget').value) || 0,
+		};
+		conn.send(JSON.stringify({'Type': 'New', 'Types': players, 'Name': $('#name').value, 'Private': priv, 'Code': code, 'Data': JSON.stringify(config)}));
 	});
 
 	$('#join').addEventListener('click', () => {
 		makeDummyHand();
+		// A private game by number and code, otherwise the selected one
+		if ($('#join-game').value != '') {
+			sendJoin(parseInt($('#join-game').value), $('#join-code').value);
+			return;
+		}
 		const select = $('#games-select');
 		const opt = select.options[select.selectedIndex];
 		if (!opt) {
 			return;
 		}
-		const key = parseInt(opt.innerText.slice(5));
-		conn.send(JSON.stringify({'Type': 'Join', 'Game': key, 'Name': $('#name').value}));
+		sendJoin(parseInt(opt.value), '');
+	});
+
+	$('#hint').addEventListener('click', () => {
+		conn.send(JSON.stringify({'Type': 'Hint', 'Game': gameId}));
+	});
+
+	// Bots play each other while we watch every hand
+	$('#exhibition').addEventListener('click', () => {
+		const types = players;
+		if (types.includes('Human')) {
+			alert('Exhibitions are bots only');
+			return;
+		}
+		const ex = {'Delay': parseInt($('#ex-delay').value)};
+		conn.send(JSON.stringify({'Type': 'Exhibition', 'Types': types, 'Name': $('#name').value, 'Data': JSON.stringify(ex)}));
+	});
+
+	$('#pause').addEventListener('click', () => conn.send(JSON.stringify({'Type': 'Pause'})));
+	$('#step').addEventListener('click', () => conn.send(JSON.stringify({'Type': 'Step'})));
+	$('#resume').addEventListener('click', () => conn.send(JSON.stringify({'Type': 'Resume'})));
+
+	$('#spectate').addEventListener('click', () => {
+		const select = $('#live-select');
+		const opt = select.options[select.selectedIndex];
+		if (!opt) {
+			return;
+		}
+		const key = parseInt(opt.value);
+		const open = $('#spectate-open').checked;
+		conn.send(JSON.stringify({'Type': 'Spectate', 'Game': key, 'Name': $('#name').value, 'Data': JSON.stringify(open)}));
+	});
+
+	$('#open-table').addEventListener('change', () => {
+		conn.send(JSON.stringify({'Type': 'OpenTable', 'Game': gameId, 'Data': JSON.stringify($('#open-table').checked)}));
+	});
+
+	// Finished games that can be replayed
+	function updateFinished(lst) {
+		fillSelect($('#finished-select'), lst);
+	}
+
+	// Replace the options with game keys, keeping the selection
+	function fillSelect(select, keys, labels) {
+		const selected = select.value;
+		select.innerHTML = '';
+		for (let i=0; i<keys.length; i++) {
+			const opt = document.createElement('option');
+			opt.value = keys[i];
+			opt.innerText = labels ? labels[i] : `Game ${keys[i]}`;
+			select.appendChild(opt);
+		}
+		select.value = selected;
+	}
+
+	let replayGame = -1;
+	let replayMove = 0;
+	let replayMoves = 0;
+
+	function sendReplay(move) {
+		if (replayGame == -1) {
+			return;
+		}
+		const pos = {'Move': move, 'Seat': parseInt($('#replay-seat').value)};
+		conn.send(JSON.stringify({'Type': 'Replay', 'Game': replayGame, 'Data': JSON.stringify(pos)}));
+	}
+
+	$('#replay').addEventListener('click', () => {
+		const select = $('#finished-select');
+		const opt = select.options[select.selectedIndex];
+		if (!opt) {
+			return;
+		}
+		replayGame = parseInt(opt.value);
+		sendReplay(0);
 	});
 
+	$('#replay-seat').addEventListener('change', () => sendReplay(replayMove));
+	$('#replay-start').addEventListener('click', () => sendReplay(0));
+	$('#replay-back').addEventListener('click', () => sendReplay(Math.max(0, replayMove-1)));
+	$('#replay-forward').addEventListener('click', () => sendReplay(Math.min(replayMoves, replayMove+1)));
+	$('#replay-end').addEventListener('click', () => sendReplay(replayMoves));
+
 	function sendChat() {
 		conn.send(JSON.stringify({'Type': 'Chat', 'Game': gameId, 'Data': $('#message').value}));
 		$('#message').value = "";
@@ -391,12 +575,8 @@ window.addEventListener('load', () => {
 		addPlayer('Human');
 	});
 	
-	$('#easy').addEventListener('click', e => {
-		addPlayer('Easy');
-	});
-	
-	$('#medium').addEventListener('click', e => {
-		addPlayer('Medium');
+	$('#computer').addEventListener('click', e => {
+		addPlayer($('#bot').value);
 	});
 
 	$('#show-known').addEventListener('change', e => {